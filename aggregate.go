@@ -0,0 +1,200 @@
+package gosher
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResultGroup - the hosts with identical results, like dshbak -c.
+// StdOut, StdErr and ExitCode - the output and the exit status shared by the hosts.
+// Error - the error of the hosts which failed without an exit status, like a connection error,
+// or which were skipped, empty if they ran the command.
+// Results - the results of the hosts in the order of the hosts.
+type ResultGroup struct {
+	StdOut   string
+	StdErr   string
+	ExitCode int
+	Error    string
+	Results  []HostResult
+}
+
+// Returns the names of the hosts in the group, see Host.Name.
+func (rg *ResultGroup) Hosts() []string {
+	names := make([]string, len(rg.Results))
+	for i, result := range rg.Results {
+		names[i] = result.Host.Name()
+	}
+	return names
+}
+
+// The output, exit code and error which identify a ResultGroup.
+type resultKey struct {
+	stdOut   string
+	stdErr   string
+	exitCode int
+	err      string
+}
+
+// Groups the results of the hosts by their stdout, stderr, exit code and error.
+// The groups with the most hosts are first, so the outliers are last.
+func AggregateResults(results []HostResult) []*ResultGroup {
+	var groups []*ResultGroup
+	byKey := make(map[resultKey]*ResultGroup)
+	for _, result := range results {
+		key := resultKey{}
+		if result.Response != nil {
+			key.stdOut = result.Response.StdOut.String()
+			key.stdErr = result.Response.StdErr.String()
+			key.exitCode = result.Response.ExitCode
+		}
+		if result.Err != nil && key.exitCode == 0 {
+			key.err = result.Err.Error()
+		}
+		group := byKey[key]
+		if group == nil {
+			group = &ResultGroup{StdOut: key.stdOut, StdErr: key.stdErr, ExitCode: key.exitCode, Error: key.err}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Results = append(group.Results, result)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Results) > len(groups[j].Results)
+	})
+	return groups
+}
+
+// Writes the groups like dshbak -c: a header with the folded hosts, see FoldHostNames,
+// their number and exit code or error, followed by their stdout and stderr.
+func RenderResultGroups(writer io.Writer, groups []*ResultGroup) error {
+	for _, group := range groups {
+		hostCount := strconv.Itoa(len(group.Results)) + " hosts"
+		if len(group.Results) == 1 {
+			hostCount = "1 host"
+		}
+		status := ""
+		if group.ExitCode != 0 {
+			status = ", exit code " + strconv.Itoa(group.ExitCode)
+		} else if group.Error != "" {
+			status = ", failed"
+		}
+		header := FoldHostNames(group.Hosts()) + " (" + hostCount + status + ")"
+		line := strings.Repeat("-", len(header))
+		if _, err := fmt.Fprintf(writer, "%s\n%s\n%s\n", line, header, line); err != nil {
+			return err
+		}
+		for _, text := range []string{group.StdOut, group.StdErr, group.Error} {
+			if text == "" {
+				continue
+			}
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			if _, err := io.WriteString(writer, text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Folds host names which differ only in their last number into ranges, like "web-[1:3],web-5,db01".
+// Numbers padded with zeros are folded with the numbers of the same length.
+// The result is a host pattern which selects the same hosts, see Inventory.Select.
+func FoldHostNames(names []string) string {
+	type foldKey struct {
+		prefix string
+		suffix string
+		width  int
+	}
+	var keys []foldKey
+	numbers := make(map[foldKey][]int)
+	var parts []string
+	partOf := make(map[foldKey]int)
+	for _, name := range names {
+		start, end := lastNumber(name)
+		if start < 0 {
+			parts = append(parts, name)
+			continue
+		}
+		digits := name[start:end]
+		number, _ := strconv.Atoi(digits)
+		key := foldKey{prefix: name[:start], suffix: name[end:]}
+		if digits[0] == '0' && len(digits) > 1 {
+			key.width = len(digits)
+		}
+		if _, exists := numbers[key]; !exists {
+			keys = append(keys, key)
+			partOf[key] = len(parts)
+			parts = append(parts, "")
+		}
+		numbers[key] = append(numbers[key], number)
+	}
+	// numbers without zeros of the same length as padded ones are folded with them
+	for _, key := range keys {
+		if key.width != 0 {
+			continue
+		}
+		var unpadded []int
+		for _, number := range numbers[key] {
+			padded := foldKey{key.prefix, key.suffix, len(strconv.Itoa(number))}
+			if _, exists := numbers[padded]; exists {
+				numbers[padded] = append(numbers[padded], number)
+			} else {
+				unpadded = append(unpadded, number)
+			}
+		}
+		numbers[key] = unpadded
+	}
+	for _, key := range keys {
+		parts[partOf[key]] = foldNumbers(key.prefix, key.suffix, key.width, numbers[key])
+	}
+	var folded []string
+	for _, part := range parts {
+		if part != "" {
+			folded = append(folded, part)
+		}
+	}
+	return strings.Join(folded, ",")
+}
+
+// Returns the position of the last number in name, -1 if it has none.
+func lastNumber(name string) (int, int) {
+	end := len(name)
+	for end > 0 && (name[end-1] < '0' || name[end-1] > '9') {
+		end--
+	}
+	if end == 0 {
+		return -1, -1
+	}
+	start := end
+	for start > 0 && name[start-1] >= '0' && name[start-1] <= '9' {
+		start--
+	}
+	return start, end
+}
+
+// Folds the numbers into ranges of consecutive numbers like "web-[1:3],web-5".
+func foldNumbers(prefix string, suffix string, width int, numbers []int) string {
+	if len(numbers) == 0 {
+		return ""
+	}
+	sort.Ints(numbers)
+	var folded []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] <= numbers[j]+1 {
+			j++
+		}
+		if numbers[j] == numbers[i] {
+			folded = append(folded, fmt.Sprintf("%s%0*d%s", prefix, width, numbers[i], suffix))
+		} else {
+			folded = append(folded, fmt.Sprintf("%s[%0*d:%0*d]%s", prefix, width, numbers[i], width, numbers[j], suffix))
+		}
+		i = j + 1
+	}
+	return strings.Join(folded, ",")
+}
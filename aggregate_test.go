@@ -0,0 +1,63 @@
+package gosher
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestFoldHostNames(t *testing.T) {
+	folds := map[string][]string{
+		"web-[1:3],web-5":       {"web-3", "web-1", "web-2", "web-5"},
+		"db[08:10].example.com": {"db08.example.com", "db09.example.com", "db10.example.com"},
+		"web-[9:10],db":         {"web-9", "db", "web-10"},
+		"10.0.0.[1:2],10.0.1.7": {"10.0.0.1", "10.0.0.2", "10.0.1.7"},
+		"r[1:2]-x":              {"r1-x", "r2-x", "r2-x"},
+		"":                      {},
+	}
+	for expected, names := range folds {
+		assert.Equal(t, expected, FoldHostNames(names))
+	}
+	names, err := ExpandHostRange("db[08:10].example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, "db[08:10].example.com", FoldHostNames(names), "folding should reverse ExpandHostRange")
+}
+
+func testResult(index int, name string, stdout string, exitCode int, err error) HostResult {
+	response := &SshResponse{ExitCode: exitCode}
+	response.StdOut.WriteString(stdout)
+	return HostResult{Index: index, Host: &Host{Client: &SshClient{Address: name}}, Response: response, Err: err}
+}
+
+func TestAggregateResults(t *testing.T) {
+	exitErr := errors.New("There was an error while executing the command: exit status 1")
+	results := []HostResult{
+		testResult(0, "web-1", "5.15\n", 0, nil),
+		testResult(1, "web-2", "5.4\n", 0, nil),
+		testResult(2, "web-3", "5.15\n", 0, nil),
+		testResult(3, "web-4", "", 1, exitErr),
+		{Index: 4, Host: &Host{Client: &SshClient{Address: "web-5"}}, Err: errors.New("connection refused")},
+		testResult(5, "web-6", "5.15\n", 0, nil),
+	}
+	groups := AggregateResults(results)
+	assert.Equal(t, 4, len(groups))
+	assert.Equal(t, []string{"web-1", "web-3", "web-6"}, groups[0].Hosts(), "the largest group should be first")
+	assert.Equal(t, "5.15\n", groups[0].StdOut)
+	assert.Equal(t, []string{"web-2"}, groups[1].Hosts())
+	assert.Equal(t, 1, groups[2].ExitCode)
+	assert.Equal(t, "", groups[2].Error, "the error of a failed command is its exit code")
+	assert.Equal(t, "connection refused", groups[3].Error)
+
+	var output bytes.Buffer
+	assert.Nil(t, RenderResultGroups(&output, groups))
+	assert.True(t, strings.HasPrefix(output.String(), strings.Repeat("-", 27)+"\n"+
+		"web-1,web-3,web-6 (3 hosts)\n"+
+		strings.Repeat("-", 27)+"\n"+
+		"5.15\n"+
+		strings.Repeat("-", 14)+"\n"+
+		"web-2 (1 host)\n"), output.String())
+	assert.Contains(t, output.String(), "web-4 (1 host, exit code 1)\n")
+	assert.Contains(t, output.String(), "web-5 (1 host, failed)\n"+strings.Repeat("-", 22)+"\nconnection refused\n")
+}
@@ -0,0 +1,351 @@
+package gosher
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CompressionNone = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+// Remote tar options and the command which is needed for every compression.
+var archiveCompressions = map[int]struct {
+	tarOption     string
+	remoteCommand string
+}{
+	CompressionNone: {"", "tar"},
+	CompressionGzip: {"z", "gzip"},
+	CompressionZstd: {"", "zstd"},
+}
+
+// Reports whether tar and the tool for the compression are available on the remote machine.
+func (s *SshClient) canArchive(compression int) bool {
+	command := "command -v tar"
+	if tool := archiveCompressions[compression].remoteCommand; tool != "tar" {
+		command += " && command -v " + tool
+	}
+	_, err := s.output(command)
+	return err == nil
+}
+
+// Uploads a folder as a single tar stream which is extracted to remotePath.
+// remotePath is quoted, so a leading ~ has to be expanded first, see uploadWithProgress.
+// Returns false if tar isn't available on the remote machine and nothing was uploaded.
+func (s *SshClient) uploadArchive(localPath string, remotePath string, meter *transferMeter,
+	options TransferOptions) (*SshResponse, bool, error) {
+	if !s.canArchive(options.ArchiveCompression) {
+		return nil, false, nil
+	}
+	plan, err := planFolderUpload(localPath, options)
+	if err != nil {
+		return nil, true, err
+	}
+	response := NewSshResponse(s.Address, &s.session)
+	inPipe, err := s.session.StdinPipe()
+	if err != nil {
+		return response, true, err
+	}
+	meter.addTotal(plan.size)
+	writeErrorChannel := make(chan error, 1)
+	go func() {
+		defer inPipe.Close()
+		writeErrorChannel <- writeArchive(inPipe, plan, meter, options.ArchiveCompression)
+	}()
+	quotedPath := shellQuote(remotePath)
+	extractCommand := fmt.Sprintf("mkdir -p %s && tar -C %s -x%sf -", quotedPath, quotedPath,
+		archiveCompressions[options.ArchiveCompression].tarOption)
+	if options.ArchiveCompression == CompressionZstd {
+		extractCommand = fmt.Sprintf("mkdir -p %s && zstd -dc | tar -C %s -xf -", quotedPath, quotedPath)
+	}
+	if err := s.session.Run(extractCommand); err != nil {
+		return response, true, NewSshConnectionError("Error while uploading: " + err.Error())
+	}
+	if err := <-writeErrorChannel; err != nil {
+		return response, true, NewSshConnectionError("Error while uploading: " + err.Error())
+	}
+	return response, true, nil
+}
+
+// Writes the planned folder upload as a tar archive.
+func writeArchive(writer io.Writer, plan *folderUploadPlan, meter *transferMeter, compression int) error {
+	compressor, err := newCompressor(writer, compression)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(compressor)
+	for _, entry := range plan.entries {
+		if entry.record == 'E' {
+			continue
+		}
+		info, err := os.Stat(entry.localPath)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = entry.relativePath
+		if entry.record == 'D' {
+			header.Name += "/"
+		}
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.record == 'C' {
+			if err = copyFileToArchive(tarWriter, entry.localPath, header.Size, meter); err != nil {
+				return err
+			}
+		}
+	}
+	for relativePath, target := range plan.links {
+		header := &tar.Header{
+			Typeflag: tar.TypeSymlink,
+			Name:     relativePath,
+			Linkname: target,
+			Mode:     0777,
+		}
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	if err = tarWriter.Close(); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func copyFileToArchive(tarWriter *tar.Writer, localPath string, size int64, meter *transferMeter) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	meter.startFile(localPath)
+	// the file could have grown since its header was written
+	if _, err = io.CopyN(tarWriter, meter.reader(file), size); err != nil {
+		return err
+	}
+	meter.finishFile()
+	return nil
+}
+
+// Downloads a remote folder as a single tar stream which is extracted to destinationPath.
+// Returns false if remotePath isn't a folder or tar isn't available on the remote machine
+// and nothing was downloaded.
+func (s *SshClient) downloadArchive(remotePath string, destinationPath string, meter *transferMeter,
+	options TransferOptions) (*SshResponse, bool, error) {
+	plan, err := s.planFolderDownload(remotePath, options)
+	if err != nil || plan == nil || !s.canArchive(options.ArchiveCompression) {
+		return nil, false, err
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	s.session.Stderr = &response.StdErr
+	// the planned paths are passed on the standard input of tar
+	var paths strings.Builder
+	for _, directory := range plan.directories {
+		paths.WriteString(directory + "\x00")
+	}
+	for i, file := range plan.files {
+		paths.WriteString(file + "\x00")
+		meter.addTotal(plan.sizes[i])
+	}
+	for link := range plan.links {
+		paths.WriteString(link + "\x00")
+	}
+	s.session.Stdin = strings.NewReader(paths.String())
+	outPipe, err := s.session.StdoutPipe()
+	if err != nil {
+		return response, true, err
+	}
+	dereference := ""
+	if options.SymlinkPolicy == SymlinkFollow {
+		dereference = "h"
+	}
+	createCommand := fmt.Sprintf("cd %s && tar --null --no-recursion -T - -c%s%sf -", shellQuote(remotePath),
+		dereference, archiveCompressions[options.ArchiveCompression].tarOption)
+	if options.ArchiveCompression == CompressionZstd {
+		createCommand += " | zstd -c"
+	}
+	if err = s.session.Start(createCommand); err != nil {
+		return response, true, NewSshConnectionError("Error while downloading: " + err.Error())
+	}
+	extractErr := extractArchive(outPipe, destinationPath, meter, options.ArchiveCompression)
+	if extractErr != nil {
+		// unblock the remote tar before waiting for it
+		io.Copy(ioutil.Discard, outPipe)
+	}
+	if err = s.session.Wait(); err != nil {
+		return response, true, NewSshConnectionError("Error while downloading: " + err.Error())
+	}
+	if extractErr != nil {
+		return response, true, NewSshConnectionError("Error while downloading: " + extractErr.Error())
+	}
+	return response, true, nil
+}
+
+// Extracts a tar archive to destinationPath, entries leading outside of it are rejected.
+// The symlinks are created after everything else, so no entry is written through them,
+// and entries below a symlink which already exists in destinationPath are rejected.
+func extractArchive(reader io.Reader, destinationPath string, meter *transferMeter, compression int) error {
+	decompressor, err := newDecompressor(reader, compression)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+	if err = os.MkdirAll(destinationPath, 0755); err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(decompressor)
+	var links []*tar.Header
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			links = append(links, header)
+			continue
+		}
+		localPath, err := archiveEntryPath(destinationPath, header.Name)
+		if err != nil {
+			return err
+		}
+		if localPath == "" {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		mode := os.FileMode(header.Mode).Perm()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(localPath, mode|0700)
+		case tar.TypeLink:
+			// tar stores files which are reachable by several paths only once
+			var linkedPath string
+			if linkedPath, err = archiveEntryPath(destinationPath, header.Linkname); err == nil {
+				os.Remove(localPath)
+				err = os.Link(linkedPath, localPath)
+			}
+		case tar.TypeReg:
+			err = extractArchiveFile(tarReader, localPath, mode, meter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, header := range links {
+		localPath, err := archiveEntryPath(destinationPath, header.Name)
+		if err != nil {
+			return err
+		}
+		if localPath == "" {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		os.Remove(localPath)
+		if err = os.Symlink(header.Linkname, localPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the local path of an archive entry, empty for the destination itself.
+// Entries outside of destinationPath or below a symlink in it are rejected.
+func archiveEntryPath(destinationPath string, name string) (string, error) {
+	relativePath := path.Clean(strings.TrimPrefix(name, "./"))
+	if relativePath == "." {
+		return "", nil
+	}
+	if path.IsAbs(relativePath) || relativePath == ".." || strings.HasPrefix(relativePath, "../") {
+		return "", errors.New("Archive entry outside of the destination: " + name)
+	}
+	parentPath := destinationPath
+	for _, part := range strings.Split(path.Dir(relativePath), "/") {
+		if part == "." {
+			break
+		}
+		parentPath = filepath.Join(parentPath, part)
+		info, err := os.Lstat(parentPath)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New("Archive entry below a symlink: " + name)
+		}
+	}
+	return filepath.Join(destinationPath, filepath.FromSlash(relativePath)), nil
+}
+
+func extractArchiveFile(reader io.Reader, localPath string, mode os.FileMode, meter *transferMeter) error {
+	// a symlink is replaced instead of writing to its target
+	if info, err := os.Lstat(localPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(localPath)
+	}
+	file, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	meter.startFile(localPath)
+	if _, err = io.Copy(meter.writer(file), reader); err != nil {
+		return err
+	}
+	meter.finishFile()
+	return file.Close()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func newCompressor(writer io.Writer, compression int) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(writer), nil
+	case CompressionZstd:
+		return zstd.NewWriter(writer)
+	}
+	return nopWriteCloser{writer}, nil
+}
+
+func newDecompressor(reader io.Reader, compression int) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewReader(reader)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(reader), nil
+}
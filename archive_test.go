@@ -0,0 +1,95 @@
+package gosher
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveEntryPath(t *testing.T) {
+	localPath, err := archiveEntryPath("/tmp/dest", "./lib/a.go")
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join("/tmp/dest", "lib", "a.go"), localPath)
+	localPath, err = archiveEntryPath("/tmp/dest", "./")
+	assert.Nil(t, err)
+	assert.Equal(t, "", localPath, "the destination itself should be skipped")
+	_, err = archiveEntryPath("/tmp/dest", "lib/../../etc/passwd")
+	assert.NotNil(t, err, "entries outside of the destination should be rejected")
+	_, err = archiveEntryPath("/tmp/dest", "/etc/passwd")
+	assert.NotNil(t, err, "absolute entries should be rejected")
+}
+
+// Returns a tar archive of the given headers, regular files get their name as content.
+func testArchive(headers ...*tar.Header) *bytes.Buffer {
+	var archive bytes.Buffer
+	tarWriter := tar.NewWriter(&archive)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		header.Mode = 0644
+		tarWriter.WriteHeader(header)
+		if header.Typeflag == tar.TypeReg {
+			tarWriter.Write([]byte(header.Name))
+		}
+	}
+	tarWriter.Close()
+	return &archive
+}
+
+func TestExtractArchive(t *testing.T) {
+	destination, outside := t.TempDir(), t.TempDir()
+	err := extractArchive(testArchive(
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "lib/a.go"},
+		&tar.Header{Typeflag: tar.TypeDir, Name: "lib/"},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "lib/a.go"},
+	), destination, nil, CompressionNone)
+	assert.Nil(t, err)
+	content, _ := ioutil.ReadFile(filepath.Join(destination, "link"))
+	assert.Equal(t, "lib/a.go", string(content))
+
+	err = extractArchive(testArchive(
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "escape", Linkname: outside},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "escape/passwd"},
+	), destination, nil, CompressionNone)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(outside, "passwd"))
+	assert.True(t, os.IsNotExist(err), "nothing should be written through a symlink of the archive")
+
+	os.Symlink(outside, filepath.Join(destination, "existing"))
+	err = extractArchive(testArchive(
+		&tar.Header{Typeflag: tar.TypeReg, Name: "existing/passwd"},
+	), destination, nil, CompressionNone)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(outside, "passwd"))
+	assert.True(t, os.IsNotExist(err), "nothing should be written through a symlink of the destination")
+
+	ioutil.WriteFile(filepath.Join(outside, "target"), []byte("untouched"), 0644)
+	os.Symlink(filepath.Join(outside, "target"), filepath.Join(destination, "file"))
+	err = extractArchive(testArchive(&tar.Header{Typeflag: tar.TypeReg, Name: "file"}), destination, nil,
+		CompressionNone)
+	assert.Nil(t, err)
+	content, _ = ioutil.ReadFile(filepath.Join(outside, "target"))
+	assert.Equal(t, "untouched", string(content), "a symlink should be replaced instead of written to")
+}
+
+func TestUploadArchiveToHome(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("rm -rf ~/archivedir './~'")
+	assert.Nil(t, runError, "removing the previous test folders returned an error")
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("archived"), 0644))
+	_, uploadError := s.UploadWithOptions(dir, "~/archivedir", TransferOptions{Archive: true})
+	assert.Nil(t, uploadError, "UploadWithOptions returned an error")
+	catResponse, catError := s.Run("cat ~/archivedir/a && ls -d './~' 2>/dev/null | wc -l")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "archived0\n", catResponse.StdOut.String(),
+		"the folder should be extracted in the home directory instead of a folder named ~")
+}
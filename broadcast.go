@@ -0,0 +1,532 @@
+package gosher
+
+import (
+	"github.com/lyuboraykov/gosher/scp"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// BroadcastOptions - options of Broadcast.
+// Concurrency - 0 (no limit) by default, the maximum number of hosts receiving
+// the file/folder at the same time. The local file is read once for every
+// Concurrency hosts it is sent to directly.
+// Relay - false by default, if true the local file is read only once and sent to the
+// first hosts, every host which already received it pushes it to the remaining ones,
+// see CopyOptions.Direct for the requirements. Hosts which fail to receive it
+// from a relay get it from the local machine.
+// Fanout - 2 by default, the number of hosts which are fed from the local machine and
+// from every relaying host at the same time.
+type BroadcastOptions struct {
+	Concurrency int
+	Relay       bool
+	Fanout      int
+}
+
+// How long the hosts which start a Broadcast are gathered before the file is sent to them,
+// unless all hosts which can run at the same time have started.
+const broadcastGatherDelay = 100 * time.Millisecond
+
+// The outcome of the transfer to a single host.
+type broadcastResult struct {
+	response *SshResponse
+	err      error
+}
+
+// Uploads a file/folder to all hosts of the MultipleHostsSshClient reading it only once
+// for all of them (or for every batch of hosts), see BroadcastOptions.
+// The hosts take part as they are started by Parallelism, Serial, Canary etc. like in every
+// other operation, the file is read once for the hosts which are started at the same time.
+// A leading ~ of remotePath is the home directory of the user on every host.
+// The ProgressCallback and the BandwidthLimit of every host apply to what it receives from the local
+// machine, a host which is slower or limited holds back the others which receive the file with it.
+// The transfers between relaying hosts aren't measured.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) Broadcast(localPath string, remotePath string, options BroadcastOptions) {
+	msc.forEachHost(msc.broadcastTask(localPath, remotePath, options))
+}
+
+// Uploads a file/folder to all hosts like Broadcast and blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) BroadcastAll(localPath string, remotePath string,
+	options BroadcastOptions) []HostResult {
+	return msc.collect(msc.broadcastTask(localPath, remotePath, options))
+}
+
+func (msc *MultipleHostsSshClient) broadcastTask(localPath string, remotePath string,
+	options BroadcastOptions) hostTask {
+	if msc.CheckMode {
+		// nothing is transferred, every host compares its files
+		return func(index int, client *SshClient) (*SshResponse, error) {
+			return client.uploadWithProgress(localPath, remotePath, TransferOptions{}, nil)
+		}
+	}
+	return newBroadcaster(msc, localPath, remotePath, options).transfer
+}
+
+func newBroadcaster(msc *MultipleHostsSshClient, localPath string, remotePath string,
+	options BroadcastOptions) *broadcaster {
+	if options.Fanout <= 0 {
+		options.Fanout = 2
+	}
+	return &broadcaster{
+		msc:        msc,
+		remotePath: remotePath,
+		options:    options,
+		aggregator: newProgressAggregator(msc.ProgressCallback),
+		upload: func(clients []*SshClient, progress []ProgressCallback) []broadcastResult {
+			return broadcastBatch(localPath, remotePath, clients, progress)
+		},
+		sources: map[int]*SshClient{},
+		slots:   map[int]int{},
+	}
+}
+
+// broadcaster - the state of a Broadcast, the task of every host waits in it until the file
+// is sent to the host from the local machine or from a relaying host.
+type broadcaster struct {
+	msc        *MultipleHostsSshClient
+	remotePath string
+	options    BroadcastOptions
+	aggregator *progressAggregator
+	// sends the local file to the clients reading it once, reporting the progress of every client
+	upload func(clients []*SshClient, progress []ProgressCallback) []broadcastResult
+	mutex  sync.Mutex
+	// the hosts waiting for the file, the ones which failed to receive it from a relay first
+	waiting  []*broadcastRequest
+	active   int
+	finished int
+	// the hosts which have the file and their free relay slots
+	sources   map[int]*SshClient
+	slots     map[int]int
+	localBusy bool
+	gathering *time.Timer
+	gathered  bool
+}
+
+// A host waiting for the file.
+type broadcastRequest struct {
+	index       int
+	client      *SshClient
+	relayFailed bool
+	result      chan broadcastResult
+}
+
+// The task of every host, it waits until the file is sent to the host.
+func (b *broadcaster) transfer(index int, client *SshClient) (*SshResponse, error) {
+	request := &broadcastRequest{index: index, client: client, result: make(chan broadcastResult, 1)}
+	b.mutex.Lock()
+	b.waiting = append(b.waiting, request)
+	b.schedule()
+	b.mutex.Unlock()
+	var done <-chan struct{}
+	if client.context != nil {
+		done = client.context.Done()
+	}
+	select {
+	case result := <-request.result:
+		return result.response, result.err
+	case <-done:
+		b.mutex.Lock()
+		for i, waiting := range b.waiting {
+			if waiting == request {
+				// it hasn't started, a running transfer fails on its own as its connection is closed
+				b.waiting = append(b.waiting[:i:i], b.waiting[i+1:]...)
+				b.finished++
+				b.schedule()
+				b.mutex.Unlock()
+				return nil, client.context.Err()
+			}
+		}
+		b.mutex.Unlock()
+		result := <-request.result
+		return result.response, result.err
+	}
+}
+
+// Starts the transfers which can start, it is called with the mutex locked whenever something changes.
+func (b *broadcaster) schedule() {
+	canStart := func() bool {
+		return b.options.Concurrency <= 0 || b.active < b.options.Concurrency
+	}
+	if b.options.Relay {
+		for source := range b.slots {
+			for b.slots[source] > 0 && canStart() {
+				request := b.take(false)
+				if request == nil {
+					break
+				}
+				b.slots[source]--
+				b.active++
+				go b.relay(source, b.sources[source], request)
+			}
+		}
+	}
+	relayFailed := len(b.waiting) > 0 && b.waiting[0].relayFailed
+	if b.localBusy || len(b.waiting) == 0 || !canStart() || b.options.Relay && len(b.slots) > 0 && !relayFailed {
+		return
+	}
+	// nobody has the file yet, all of them failed or a relay failed, send it from the local machine
+	batchSize := len(b.msc.Hosts)
+	if b.options.Relay {
+		batchSize = b.options.Fanout
+	}
+	if b.options.Concurrency > 0 && b.options.Concurrency-b.active < batchSize {
+		batchSize = b.options.Concurrency - b.active
+	}
+	if len(b.waiting) < batchSize && !b.gathered && !relayFailed && !b.allStarted() {
+		// wait for the other hosts which are starting, so the file is read once for all of them
+		if b.gathering == nil {
+			b.gathering = time.AfterFunc(broadcastGatherDelay, func() {
+				b.mutex.Lock()
+				defer b.mutex.Unlock()
+				b.gathering = nil
+				b.gathered = true
+				b.schedule()
+			})
+		}
+		return
+	}
+	if b.gathering != nil {
+		b.gathering.Stop()
+		b.gathering = nil
+	}
+	b.gathered = false
+	var batch []*broadcastRequest
+	for len(batch) < batchSize && len(b.waiting) > 0 {
+		batch = append(batch, b.take(true))
+	}
+	b.localBusy = true
+	b.active += len(batch)
+	go b.send(batch)
+}
+
+// Reports whether all hosts which can run at the same time are either waiting or receiving the file.
+// The hosts which are skipped aren't known, they are waited for until broadcastGatherDelay passes.
+func (b *broadcaster) allStarted() bool {
+	running := len(b.msc.Hosts) - b.finished
+	if b.msc.Parallelism > 0 && b.msc.Parallelism < running {
+		running = b.msc.Parallelism
+	}
+	return len(b.waiting)+b.active >= running
+}
+
+// Removes the first waiting host, the ones which failed to receive the file from a relay
+// only if local is true. Returns nil if there is none.
+func (b *broadcaster) take(local bool) *broadcastRequest {
+	for i, request := range b.waiting {
+		if local || !request.relayFailed {
+			b.waiting = append(b.waiting[:i:i], b.waiting[i+1:]...)
+			return request
+		}
+	}
+	return nil
+}
+
+// Sends the file from the local machine to the batch of hosts.
+func (b *broadcaster) send(batch []*broadcastRequest) {
+	clients := make([]*SshClient, len(batch))
+	progress := make([]ProgressCallback, len(batch))
+	for i, request := range batch {
+		clients[i] = request.client
+		progress[i] = b.aggregator.callbackFor(request.index, request.client)
+	}
+	results := b.upload(clients, progress)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.localBusy = false
+	for i, request := range batch {
+		b.finish(request, results[i])
+	}
+	b.schedule()
+}
+
+// Pushes the file from the source host with the given client to the host of request.
+func (b *broadcaster) relay(source int, sourceClient *SshClient, request *broadcastRequest) {
+	// a host relays to several hosts at once, each over its own connection
+	relayClient := sourceClient.clone()
+	response, err := CopyWithOptions(relayClient, b.remotePath, request.client, b.remotePath,
+		CopyOptions{Direct: true})
+	relayClient.closeClone()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.slots[source]++
+	if err != nil {
+		// the relay failed, send it from the local machine
+		b.active--
+		request.relayFailed = true
+		b.waiting = append([]*broadcastRequest{request}, b.waiting...)
+	} else {
+		b.finish(request, broadcastResult{response: response})
+	}
+	b.schedule()
+}
+
+// Passes the result to the host, which becomes a relay if it received the file.
+// It is called with the mutex locked.
+func (b *broadcaster) finish(request *broadcastRequest, result broadcastResult) {
+	b.active--
+	b.finished++
+	if result.err == nil && b.options.Relay {
+		b.sources[request.index] = request.client
+		b.slots[request.index] = b.options.Fanout
+	}
+	request.result <- result
+}
+
+// Uploads localPath to the clients reading it once and writing it to all of them.
+// The transfer to every client is measured and throttled like an Upload, progress has its callbacks.
+func broadcastBatch(localPath string, remotePath string, clients []*SshClient,
+	progress []ProgressCallback) []broadcastResult {
+	results := make([]broadcastResult, len(clients))
+	entries, links, entriesErr := broadcastEntries(localPath, remotePath)
+	size := int64(0)
+	for _, entry := range entries {
+		size += entry.size
+	}
+	var targets []*broadcastTarget
+	for i, client := range clients {
+		if entriesErr != nil {
+			results[i].err = entriesErr
+			continue
+		}
+		if err := client.newSession(); err != nil {
+			results[i].err = err
+			continue
+		}
+		// the home directory of every host is its own
+		clientPath, err := client.expandRemotePath(remotePath)
+		if err != nil {
+			results[i].err = err
+			client.closeUnlessSticky()
+			continue
+		}
+		response := new(SshResponse)
+		response.Address = client.Address
+		client.session.Stderr = &response.StdErr
+		results[i].response = response
+		inPipe, err := client.session.StdinPipe()
+		var outPipe io.Reader
+		if err == nil {
+			outPipe, err = client.session.StdoutPipe()
+		}
+		if err == nil {
+			err = client.session.Start(uploadSinkCommand(path.Dir(clientPath)))
+		}
+		if err != nil {
+			results[i].err = NewSshConnectionError("There was an error while uploading: " + err.Error())
+			client.closeUnlessSticky()
+			continue
+		}
+		meter := client.newTransferMeter(progress[i])
+		meter.addTotal(size)
+		targets = append(targets, &broadcastTarget{
+			result:     i,
+			client:     client,
+			remotePath: clientPath,
+			source:     scp.NewSource(inPipe, outPipe),
+			input:      inPipe,
+			meter:      meter,
+		})
+	}
+	sendBroadcast(targets, entries)
+	for _, target := range targets {
+		target.input.Close()
+		// scp exits with 1 if some of the files failed, these are reported instead
+		waitErr := target.client.session.Wait()
+		err := target.err
+		if err == nil && len(target.fileErrors) > 0 {
+			err = joinErrors(target.fileErrors)
+		}
+		if err == nil {
+			err = waitErr
+		}
+		if err == nil {
+			err = target.client.createRemoteLinks(target.remotePath, links)
+		}
+		if err != nil {
+			results[target.result].err = NewSshConnectionError("There was an error while uploading: " + err.Error())
+		}
+		target.client.closeUnlessSticky()
+	}
+	return results
+}
+
+// Returns the entries which upload localPath as remotePath and the symlinks which have to be created.
+func broadcastEntries(localPath string, remotePath string) ([]uploadEntry, map[string]string, error) {
+	localPathInfo, err := os.Stat(localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !localPathInfo.IsDir() {
+		entry := uploadEntry{record: 'C', localPath: localPath, relativePath: filepath.Base(remotePath),
+			mode: localPathInfo.Mode(), size: localPathInfo.Size()}
+		return []uploadEntry{entry}, nil, nil
+	}
+	plan, err := planFolderUpload(localPath, TransferOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return plan.rootEntries(filepath.Base(remotePath)), plan.links, nil
+}
+
+// broadcastTarget - a host receiving a broadcast through its own scp source.
+type broadcastTarget struct {
+	result int
+	client *SshClient
+	// the remote path with ~ expanded on the host
+	remotePath string
+	source     *scp.Source
+	input      io.Closer
+	meter      *transferMeter
+	err        error
+	fileErrors []error
+	// the entries before skipTo are skipped, they are in a directory which the host rejected
+	skipTo int
+}
+
+// Keeps the error of a single file and goes on, any other error stops the target.
+func (target *broadcastTarget) record(err error) {
+	if scp.IsFileError(err) {
+		target.fileErrors = append(target.fileErrors, err)
+	} else if err != nil {
+		target.err = err
+	}
+}
+
+// Sends the entries to all targets at the same time, the records are acknowledged by every
+// target separately and the content of every file is read only once.
+func sendBroadcast(targets []*broadcastTarget, entries []uploadEntry) {
+	for i, entry := range entries {
+		var active []*broadcastTarget
+		for _, target := range targets {
+			if target.err == nil && target.skipTo <= i {
+				active = append(active, target)
+			}
+		}
+		if len(active) == 0 {
+			return
+		}
+		if entry.record == 'C' {
+			broadcastFile(active, entry)
+			continue
+		}
+		var wait sync.WaitGroup
+		for _, target := range active {
+			wait.Add(1)
+			go func(target *broadcastTarget) {
+				defer wait.Done()
+				if entry.record == 'E' {
+					target.record(target.source.EndDirectory())
+					return
+				}
+				err := target.source.Directory(entry.mode, path.Base(entry.relativePath))
+				if scp.IsWarning(err) {
+					target.skipTo = endOfDirectory(entries, i) + 1
+				}
+				target.record(err)
+			}(target)
+		}
+		wait.Wait()
+	}
+}
+
+// Sends a file to the targets, its content is read once and written to every target through a pipe.
+func broadcastFile(targets []*broadcastTarget, entry uploadEntry) {
+	name := path.Base(entry.relativePath)
+	file, err := os.Open(entry.localPath)
+	if err != nil {
+		for _, target := range targets {
+			if warningErr := target.source.Warning(name + ": " + err.Error()); warningErr != nil {
+				target.record(warningErr)
+				continue
+			}
+			target.record(&scp.ContentError{Name: name, Err: err})
+		}
+		return
+	}
+	defer file.Close()
+	writer := &broadcastWriter{}
+	var wait sync.WaitGroup
+	for _, target := range targets {
+		reader, pipeWriter := io.Pipe()
+		writer.add(pipeWriter)
+		wait.Add(1)
+		go func(target *broadcastTarget) {
+			defer wait.Done()
+			target.meter.startFile(entry.localPath)
+			err := target.source.File(entry.mode, entry.size, name, target.meter.reader(reader))
+			target.meter.finishFile()
+			// the content of a rejected file isn't read, the writes to it fail instead of blocking
+			reader.Close()
+			target.record(err)
+		}(target)
+	}
+	_, readErr := io.Copy(writer, file)
+	writer.closeAll(readErr)
+	wait.Wait()
+}
+
+func (s *SshClient) closeUnlessSticky() {
+	if !s.StickySession {
+		s.CloseSession()
+	}
+}
+
+// broadcastWriter writes everything to all of its writers, a writer which fails
+// is dropped with its error and the rest keep receiving the data.
+// Writing fails only when all of the writers have failed.
+type broadcastWriter struct {
+	mutex   sync.Mutex
+	writers []io.WriteCloser
+	errs    []error
+}
+
+func (bw *broadcastWriter) add(writer io.WriteCloser) {
+	bw.writers = append(bw.writers, writer)
+	bw.errs = append(bw.errs, nil)
+}
+
+func (bw *broadcastWriter) Write(p []byte) (int, error) {
+	bw.mutex.Lock()
+	defer bw.mutex.Unlock()
+	var wait sync.WaitGroup
+	for i := range bw.writers {
+		if bw.errs[i] != nil {
+			continue
+		}
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			if _, err := bw.writers[i].Write(p); err != nil {
+				bw.errs[i] = err
+			}
+		}(i)
+	}
+	wait.Wait()
+	for _, err := range bw.errs {
+		if err == nil {
+			return len(p), nil
+		}
+	}
+	return 0, io.ErrClosedPipe
+}
+
+// The writers are closed separately by closeAll once everything is written.
+func (bw *broadcastWriter) Close() error {
+	return nil
+}
+
+// Closes the writers, pipes are closed with err so their readers get it.
+func (bw *broadcastWriter) closeAll(err error) {
+	for _, writer := range bw.writers {
+		if pipe, isPipe := writer.(*io.PipeWriter); isPipe && err != nil {
+			pipe.CloseWithError(err)
+			continue
+		}
+		writer.Close()
+	}
+}
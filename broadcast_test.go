@@ -0,0 +1,124 @@
+package gosher
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	failing bool
+}
+
+func (bc *bufferCloser) Write(p []byte) (int, error) {
+	if bc.failing {
+		return 0, errors.New("broken pipe")
+	}
+	return bc.Buffer.Write(p)
+}
+
+func (bc *bufferCloser) Close() error {
+	return nil
+}
+
+func TestBroadcastWriter(t *testing.T) {
+	healthy, failing := &bufferCloser{}, &bufferCloser{failing: true}
+	writer := &broadcastWriter{}
+	writer.add(healthy)
+	writer.add(failing)
+	n, err := writer.Write([]byte("release"))
+	assert.Nil(t, err, "writing should succeed while a writer is healthy")
+	assert.Equal(t, 7, n)
+	assert.Equal(t, "release", healthy.String())
+	assert.NotNil(t, writer.errs[1], "the error of the failing writer should be kept")
+	healthy.failing = true
+	_, err = writer.Write([]byte("more"))
+	assert.NotNil(t, err, "writing should fail when all writers have failed")
+}
+
+func TestBroadcastRollout(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d", "e")
+	msc.Parallelism = 2
+	var mutex sync.Mutex
+	var batches [][]string
+	var aggregated MultipleHostsProgress
+	msc.ProgressCallback = func(progress MultipleHostsProgress) {
+		aggregated = progress
+	}
+	upload := func(clients []*SshClient, progress []ProgressCallback) []broadcastResult {
+		results := make([]broadcastResult, len(clients))
+		var batch []string
+		for i, client := range clients {
+			batch = append(batch, client.Address)
+			progress[i](TransferProgress{Address: client.Address, BytesTransferred: 10, TotalBytes: 10})
+			results[i].response = &SshResponse{}
+			if client.Address == "c" {
+				results[i].err = errors.New("disk full")
+			}
+		}
+		sort.Strings(batch)
+		mutex.Lock()
+		batches = append(batches, batch)
+		mutex.Unlock()
+		return results
+	}
+	b := newBroadcaster(msc, "release.tar", "release.tar", BroadcastOptions{})
+	b.upload = upload
+	results := msc.collect(b.transfer)
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, batches,
+		"the file should be read once for the hosts running at the same time")
+	assert.NotNil(t, results[2].Err)
+	assert.Nil(t, results[4].Err)
+	assert.Equal(t, 5, len(aggregated.Hosts), "the progress of every host should be aggregated")
+	assert.Equal(t, int64(50), aggregated.BytesTransferred)
+
+	batches = nil
+	msc.Serial = "2"
+	b = newBroadcaster(msc, "release.tar", "release.tar", BroadcastOptions{Concurrency: 1})
+	b.upload = upload
+	results = msc.collect(b.transfer)
+	assert.Equal(t, 4, len(batches), "Concurrency should limit the batches further")
+	assert.True(t, results[4].Skipped(), "the rollout should be aborted after the failed batch")
+}
+
+func TestBroadcastToHome(t *testing.T) {
+	client, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := client.Run("rm -rf ~/'broadcast $dir' && mkdir ~/'broadcast $dir'")
+	assert.Nil(t, runError, "creating the test folder returned an error")
+	ioutil.WriteFile("broadcastfile", []byte("release"), 0644)
+	defer os.Remove("broadcastfile")
+	os.Chmod("broadcastfile", 0750)
+
+	results := broadcastBatch("broadcastfile", "~/broadcast $dir/release", []*SshClient{client}, []ProgressCallback{nil})
+	assert.Nil(t, results[0].err, "broadcasting to a folder in the home directory returned an error")
+	catResponse, catError := client.Run("stat -c %a ~/'broadcast $dir'/release && cat ~/'broadcast $dir'/release")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "750\nrelease", catResponse.StdOut.String(), "the file should be sent with its mode")
+}
+
+func TestBroadcastProgress(t *testing.T) {
+	client, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	client.BandwidthLimit = 4000
+	ioutil.WriteFile("broadcastfile", bytes.Repeat([]byte("r"), 2000), 0644)
+	defer os.Remove("broadcastfile")
+	var last TransferProgress
+	progress := func(progress TransferProgress) {
+		last = progress
+	}
+
+	start := time.Now()
+	results := broadcastBatch("broadcastfile", "broadcastfile", []*SshClient{client}, []ProgressCallback{progress})
+	assert.Nil(t, results[0].err, "Broadcast returned an error")
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "the transfer should be throttled")
+	assert.Equal(t, int64(2000), last.BytesTransferred, "the progress of the host should be reported")
+	assert.Equal(t, int64(2000), last.TotalBytes)
+}
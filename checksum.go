@@ -0,0 +1,172 @@
+package gosher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Remote shell function which prints the SHA-256 of its standard input
+// using sha256sum, shasum or openssl, whichever is available.
+const remoteChecksumFunction = "gosher_sha256() { " +
+	"if command -v sha256sum >/dev/null 2>&1; then sha256sum; " +
+	"elif command -v shasum >/dev/null 2>&1; then shasum -a 256; " +
+	"else openssl dgst -sha256 -r; fi; }; "
+
+// How many remote files are handled by a single command.
+const remoteBatchSize = 500
+
+// Returns the hex encoded SHA-256 of the first length bytes of a remote file,
+// of the whole file if length is negative.
+func (s *SshClient) remoteChecksum(remotePath string, length int64) (string, error) {
+	command := remoteChecksumFunction + "gosher_sha256 < " + shellQuote(remotePath)
+	if length >= 0 {
+		command = fmt.Sprintf("%shead -c %d %s | gosher_sha256", remoteChecksumFunction, length, shellQuote(remotePath))
+	}
+	checksumOutput, err := s.output(command)
+	if err != nil {
+		return "", NewSshConnectionError("There was an error while computing the checksum of " +
+			remotePath + ": " + err.Error())
+	}
+	checksum := parseChecksum(checksumOutput)
+	if checksum == "" {
+		return "", NewSshConnectionError("Invalid checksum of " + remotePath + ": " + checksumOutput)
+	}
+	return checksum, nil
+}
+
+// Returns the hex encoded SHA-256 of every remote file in the same order,
+// the checksum of files which can't be read is empty.
+func (s *SshClient) remoteChecksums(remotePaths []string) ([]string, error) {
+	checksums := make([]string, 0, len(remotePaths))
+	for start := 0; start < len(remotePaths); start += remoteBatchSize {
+		end := start + remoteBatchSize
+		if end > len(remotePaths) {
+			end = len(remotePaths)
+		}
+		quotedPaths := make([]string, 0, end-start)
+		for _, remotePath := range remotePaths[start:end] {
+			quotedPaths = append(quotedPaths, shellQuote(remotePath))
+		}
+		command := remoteChecksumFunction + "for f in " + strings.Join(quotedPaths, " ") +
+			`; do gosher_sha256 < "$f" 2>/dev/null || echo -; done`
+		checksumOutput, err := s.output(command)
+		if err != nil {
+			return nil, NewSshConnectionError("There was an error while computing checksums: " + err.Error())
+		}
+		lines := strings.Split(checksumOutput, "\n")
+		if len(lines) != end-start {
+			return nil, NewSshConnectionError("Unexpected output while computing checksums: " + checksumOutput)
+		}
+		for _, line := range lines {
+			checksums = append(checksums, parseChecksum(line))
+		}
+	}
+	return checksums, nil
+}
+
+// Returns the first SHA-256 hex digest in the output of sha256sum, shasum or openssl dgst.
+func parseChecksum(output string) string {
+	for _, field := range strings.Fields(output) {
+		if len(field) == sha256.Size*2 {
+			if _, err := hex.DecodeString(field); err == nil {
+				return strings.ToLower(field)
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the hex encoded SHA-256 of the first length bytes of a local file,
+// of the whole file if length is negative.
+func localChecksum(localPath string, length int64) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if length >= 0 {
+		_, err = io.CopyN(hash, file, length)
+	} else {
+		_, err = io.Copy(hash, file)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Compares every uploaded file under localPath with its copy under remotePath.
+func (s *SshClient) verifyUpload(localPath string, remotePath string, options TransferOptions) error {
+	localPathInfo, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if !localPathInfo.IsDir() {
+		return s.compareChecksums([]string{localPath}, []string{remotePath}, []string{remotePath})
+	}
+	plan, err := planFolderUpload(localPath, options)
+	if err != nil {
+		return err
+	}
+	var localPaths, remotePaths []string
+	for _, entry := range plan.entries {
+		if entry.record == 'C' {
+			localPaths = append(localPaths, entry.localPath)
+			remotePaths = append(remotePaths, path.Join(remotePath, entry.relativePath))
+		}
+	}
+	return s.compareChecksums(localPaths, remotePaths, remotePaths)
+}
+
+// Compares every file under remotePath with its downloaded copy under localPath.
+func (s *SshClient) verifyDownload(remotePath string, localPath string, options TransferOptions) error {
+	// the NUL separated listing keeps every file name intact
+	plan, err := s.planFolderDownload(remotePath, options)
+	if err != nil {
+		return err
+	}
+	// a single file is verified against the destination path itself
+	relativePaths := []string{""}
+	if plan != nil {
+		relativePaths = plan.files
+	}
+	var localPaths, remotePaths []string
+	for _, relativePath := range relativePaths {
+		remotePaths = append(remotePaths, path.Join(remotePath, relativePath))
+		localPaths = append(localPaths, filepath.Join(localPath, filepath.FromSlash(relativePath)))
+	}
+	return s.compareChecksums(localPaths, remotePaths, localPaths)
+}
+
+// Returns a ChecksumMismatchError with the destinationPaths of the files
+// whose local and remote checksums differ.
+func (s *SshClient) compareChecksums(localPaths []string, remotePaths []string, destinationPaths []string) error {
+	remoteChecksums, err := s.remoteChecksums(remotePaths)
+	if err != nil {
+		return err
+	}
+	return checksumMismatches(localPaths, remoteChecksums, destinationPaths)
+}
+
+// Returns a ChecksumMismatchError with the destinationPaths of the local files which are missing
+// or whose checksums differ from remoteChecksums, a missing remote file has an empty checksum.
+func checksumMismatches(localPaths []string, remoteChecksums []string, destinationPaths []string) error {
+	var mismatches []string
+	for i := range localPaths {
+		checksum, err := localChecksum(localPaths[i], -1)
+		if err != nil || remoteChecksums[i] == "" || checksum != remoteChecksums[i] {
+			mismatches = append(mismatches, destinationPaths[i])
+		}
+	}
+	if len(mismatches) > 0 {
+		return NewChecksumMismatchError(mismatches)
+	}
+	return nil
+}
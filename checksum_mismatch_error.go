@@ -0,0 +1,21 @@
+package gosher
+
+import "strings"
+
+// Error returned by transfers with TransferOptions.Verify when the SHA-256
+// of some of the transferred files differs between the local and the remote machine.
+// Paths are the destination paths of these files.
+type ChecksumMismatchError struct {
+	Paths []string
+}
+
+// Returns the error message of the ChecksumMismatchError
+func (ce *ChecksumMismatchError) Error() string {
+	return "Checksum mismatch after transfer: " + strings.Join(ce.Paths, ", ")
+}
+
+func NewChecksumMismatchError(paths []string) *ChecksumMismatchError {
+	return &ChecksumMismatchError{
+		Paths: paths,
+	}
+}
@@ -0,0 +1,70 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	digest := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	assert.Equal(t, digest, parseChecksum(digest+"  -"), "sha256sum output wasn't parsed")
+	assert.Equal(t, digest, parseChecksum(digest+" *stdin"), "openssl -r output wasn't parsed")
+	assert.Equal(t, digest, parseChecksum("(stdin)= "+digest), "openssl output wasn't parsed")
+	assert.Equal(t, "", parseChecksum("-"), "missing file should have an empty checksum")
+}
+
+func TestChecksumMismatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	intact, modified := filepath.Join(dir, "intact"), filepath.Join(dir, "modified")
+	assert.Nil(t, ioutil.WriteFile(intact, []byte("release"), 0644))
+	assert.Nil(t, ioutil.WriteFile(modified, []byte("release"), 0644))
+	checksum, err := localChecksum(intact, -1)
+	assert.Nil(t, err)
+	checksums := []string{checksum, checksum}
+	paths := []string{intact, modified}
+	assert.Nil(t, checksumMismatches(paths, checksums, paths), "identical files shouldn't mismatch")
+
+	assert.Nil(t, ioutil.WriteFile(modified, []byte("corrupted"), 0644))
+	err = checksumMismatches(paths, checksums, paths)
+	if assert.IsType(t, &ChecksumMismatchError{}, err) {
+		assert.Equal(t, []string{modified}, err.(*ChecksumMismatchError).Paths)
+	}
+	missing := filepath.Join(dir, "missing")
+	err = checksumMismatches([]string{intact, missing}, []string{"", checksum}, []string{intact, missing})
+	if assert.IsType(t, &ChecksumMismatchError{}, err) {
+		assert.Equal(t, []string{intact, missing}, err.(*ChecksumMismatchError).Paths,
+			"a missing remote or local file should mismatch")
+	}
+}
+
+func TestVerifyUploadToHome(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	ioutil.WriteFile("verifyfile", []byte("verified"), 0644)
+	defer os.Remove("verifyfile")
+	_, uploadError := s.UploadWithOptions("verifyfile", "~/verifyfile", TransferOptions{Verify: true})
+	assert.Nil(t, uploadError, "a file uploaded to the home directory should be verified")
+	catResponse, catError := s.Run("cat ~/verifyfile")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "verified", catResponse.StdOut.String())
+}
+
+func TestVerifyDownloadOfNamesWithSpaces(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("rm -rf verifydir && mkdir verifydir && echo b > 'verifydir/trailing  '")
+	assert.Nil(t, runError, "creating the test files returned an error")
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	_, downloadError := s.DownloadWithOptions("verifydir", dir, TransferOptions{Verify: true})
+	assert.Nil(t, downloadError, "files with trailing spaces in their names should be verified")
+	content, readErr := ioutil.ReadFile(filepath.Join(dir, "verifydir", "trailing  "))
+	assert.Nil(t, readErr, "reading the downloaded file returned an error")
+	assert.Equal(t, "b\n", string(content))
+}
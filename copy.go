@@ -0,0 +1,202 @@
+package gosher
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CopyOptions - options of CopyWithOptions.
+// Direct - false by default, if true the source machine pushes the files straight to
+// the destination machine over ssh instead of streaming them through the local process.
+// The source machine has to be able to reach and authenticate to the destination
+// non-interactively (with keys or an agent) and the destination has to be in its known hosts.
+// DirectAddress - the address of the destination as seen from the source machine,
+// the Address of the destination client by default.
+// NoOverwrite - false by default, if true the copy fails without copying anything when
+// destinationPath already exists, or the path the source is copied to if it is a folder.
+type CopyOptions struct {
+	Direct        bool
+	DirectAddress string
+	NoOverwrite   bool
+}
+
+// Copies file/folder from one remote machine to another.
+// The contents are streamed from the source session to the destination session through
+// the local process without touching the local disk.
+// A leading ~ of either path is the home directory of the user on that machine.
+// Returns an SshResponse of the destination and an error if any has occured.
+func Copy(source *SshClient, sourcePath string, destination *SshClient, destinationPath string) (*SshResponse, error) {
+	return CopyWithOptions(source, sourcePath, destination, destinationPath, CopyOptions{})
+}
+
+// Copies file/folder from one remote machine to another with the given CopyOptions.
+// Returns an SshResponse and an error if any has occured, the response is of the
+// destination when the contents are streamed and of the source when they are pushed directly.
+func CopyWithOptions(source *SshClient, sourcePath string, destination *SshClient, destinationPath string,
+	options CopyOptions) (*SshResponse, error) {
+	if options.NoOverwrite {
+		if err := destination.checkCopyTarget(sourcePath, destinationPath); err != nil {
+			return nil, err
+		}
+	}
+	if options.Direct {
+		return source.pushTo(sourcePath, destination, destinationPath, options.DirectAddress)
+	}
+	return relayCopy(source, sourcePath, destination, destinationPath)
+}
+
+// Runs scp in source mode on the source machine and in sink mode on the destination machine
+// and connects their standard streams, so the acknowledgements flow both ways.
+func relayCopy(source *SshClient, sourcePath string, destination *SshClient,
+	destinationPath string) (*SshResponse, error) {
+	for _, client := range []*SshClient{source, destination} {
+		if sessionErr := client.newSession(); sessionErr != nil {
+			return nil, sessionErr
+		}
+		if !client.StickySession {
+			defer client.CloseSession()
+		}
+	}
+	sourceSession, err := source.connection.NewSession()
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer sourceSession.Close()
+	destinationSession, err := destination.connection.NewSession()
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer destinationSession.Close()
+	if sourcePath, err = source.expandRemotePath(sourcePath); err != nil {
+		return nil, err
+	}
+	if destinationPath, err = destination.expandRemotePath(destinationPath); err != nil {
+		return nil, err
+	}
+
+	response := new(SshResponse)
+	response.Address = destination.Address
+	destinationSession.Stderr = &response.StdErr
+	var sourceErr bytes.Buffer
+	sourceSession.Stderr = &sourceErr
+	sourceOut, err := sourceSession.StdoutPipe()
+	if err != nil {
+		return response, err
+	}
+	destinationOut, err := destinationSession.StdoutPipe()
+	if err != nil {
+		return response, err
+	}
+	destinationSession.Stdin = sourceOut
+	sourceSession.Stdin = destinationOut
+
+	if err = destinationSession.Start("/usr/bin/scp -qrt " + shellQuote(destinationPath)); err != nil {
+		return response, NewSshConnectionError("There was an error while copying: " + err.Error())
+	}
+	if err = sourceSession.Start("/usr/bin/scp -rf " + shellQuote(sourcePath)); err != nil {
+		return response, NewSshConnectionError("There was an error while copying: " + err.Error())
+	}
+	sourceWaitErr := sourceSession.Wait()
+	destinationWaitErr := destinationSession.Wait()
+	if sourceWaitErr != nil {
+		return response, NewSshConnectionError(strings.TrimSpace("There was an error while copying from " +
+			source.Address + ": " + sourceWaitErr.Error() + " " + sourceErr.String() + response.StdErr.String()))
+	}
+	if destinationWaitErr != nil {
+		return response, NewSshConnectionError("There was an error while copying to " + destination.Address + ": " +
+			destinationWaitErr.Error())
+	}
+	return response, nil
+}
+
+// Returns an error if the path sourcePath would be copied to on the remote machine already exists.
+func (s *SshClient) checkCopyTarget(sourcePath string, destinationPath string) error {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	destinationPath, err := s.expandRemotePath(destinationPath)
+	if err != nil {
+		return err
+	}
+	existingPath, err := s.output(copyTargetCommand(sourcePath, destinationPath))
+	if err != nil {
+		return NewSshConnectionError("There was an error while checking " + destinationPath + ": " + err.Error())
+	}
+	if existingPath != "" {
+		return NewSshConnectionError("There was an error while copying: " + existingPath + " already exists")
+	}
+	return nil
+}
+
+// Builds the command which prints the path sourcePath is copied to if it exists,
+// it is inside destinationPath if that is a folder, like with scp.
+func copyTargetCommand(sourcePath string, destinationPath string) string {
+	quotedPath := shellQuote(destinationPath)
+	return fmt.Sprintf(`target=%s; if [ -d %s ]; then target=%s/%s; fi; `+
+		`if [ -e "$target" ] || [ -L "$target" ]; then printf '%%s\n' "$target"; fi`,
+		quotedPath, quotedPath, quotedPath, shellQuote(path.Base(sourcePath)))
+}
+
+// Makes the remote machine copy sourcePath to the destination over its own ssh connection.
+func (s *SshClient) pushTo(sourcePath string, destination *SshClient, destinationPath string,
+	destinationAddress string) (*SshResponse, error) {
+	if destinationAddress == "" {
+		destinationAddress = destination.Address
+	}
+	// only a destination path in the home directory needs a connection to the destination
+	if isHomeRelative(destinationPath) {
+		var err error
+		if destinationPath, err = destination.expandRemotePathInSession(destinationPath); err != nil {
+			return nil, err
+		}
+	}
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	sourcePath, err := s.expandRemotePath(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	response := NewSshResponse(s.Address, &s.session)
+	command := directCopyCommand(sourcePath, destination.clientConfiguration.User, destinationAddress,
+		destination.Port, destinationPath)
+	if err := s.session.Run(command); err != nil {
+		return response, NewSshConnectionError("There was an error while copying: " + err.Error())
+	}
+	return response, nil
+}
+
+// Expands a leading ~ of remotePath in a session of its own, see expandRemotePath.
+func (s *SshClient) expandRemotePathInSession(remotePath string) (string, error) {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return "", sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	return s.expandRemotePath(remotePath)
+}
+
+// Builds the command which connects a local scp in source mode with a remote scp in sink mode
+// through a fifo, so it works with every scp version and quoting of the remote path is exact.
+// A pipeline only exits with the status of its last command, so the status of the local scp
+// is written to a file and takes precedence if it failed.
+func directCopyCommand(sourcePath string, user string, address string, port int, destinationPath string) string {
+	sinkCommand := "/usr/bin/scp -qrt " + shellQuote(destinationPath)
+	return fmt.Sprintf(`fifo=$(mktemp -u) && mkfifo "$fifo" && { `+
+		`{ /usr/bin/scp -rf %s < "$fifo"; echo $? > "$fifo.status"; } | ssh -p %d -o BatchMode=yes %s %s > "$fifo"; `+
+		`status=$?; sourceStatus=$(cat "$fifo.status" 2>/dev/null || echo 1); rm -f "$fifo" "$fifo.status"; `+
+		`if [ "$sourceStatus" != 0 ]; then status=$sourceStatus; fi; exit $status; }`,
+		shellQuote(sourcePath), port, shellQuote(user+"@"+address), shellQuote(sinkCommand))
+}
@@ -0,0 +1,68 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDirectCopyCommand(t *testing.T) {
+	command := directCopyCommand("/var/backups/db dump.sql", "deploy", "10.0.0.2", 2222, "/srv/it's")
+	assert.Contains(t, command, "/usr/bin/scp -rf '/var/backups/db dump.sql'", "source path should be quoted")
+	assert.Contains(t, command, "ssh -p 2222 -o BatchMode=yes 'deploy@10.0.0.2'", "destination should be used")
+	assert.Contains(t, command, `'/usr/bin/scp -qrt '\''/srv/it'\''\'\'''\''s'\'''`,
+		"destination path should be quoted for both shells")
+	assert.Contains(t, command, `echo $? > "$fifo.status"`, "the status of the source scp should be kept")
+	assert.Contains(t, command, `if [ "$sourceStatus" != 0 ]; then status=$sourceStatus; fi`,
+		"a failed source scp should fail the copy")
+}
+
+func TestCopyTargetCommand(t *testing.T) {
+	command := copyTargetCommand("/var/backups/db dump.sql", "/srv/it's")
+	assert.Contains(t, command, `target='/srv/it'\''s'`, "destination path should be quoted")
+	assert.Contains(t, command, `target='/srv/it'\''s'/'db dump.sql'`,
+		"the source should be copied into an existing folder under its name")
+}
+
+func TestCopyErrors(t *testing.T) {
+	source, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	destination, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := source.Run("rm -f copymissing; echo copied > copysource; echo existing > copydestination")
+	assert.Nil(t, runError, "creating the test files returned an error")
+
+	_, copyError := Copy(source, "copymissing", destination, "copydestination")
+	assert.NotNil(t, copyError, "copying a missing file should fail")
+
+	_, copyError = CopyWithOptions(source, "copysource", destination, "copydestination", CopyOptions{NoOverwrite: true})
+	assert.NotNil(t, copyError, "copying over an existing file should fail with NoOverwrite")
+	catResponse, catError := destination.Run("cat copydestination")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "existing\n", catResponse.StdOut.String(), "the existing file shouldn't be overwritten")
+
+	_, copyError = Copy(source, "copysource", destination, "copydestination")
+	assert.Nil(t, copyError, "Copy returned an error")
+	catResponse, catError = destination.Run("cat copydestination")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "copied\n", catResponse.StdOut.String(), "the existing file should be overwritten by default")
+}
+
+func TestCopyInHome(t *testing.T) {
+	source, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	destination, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := source.Run("echo copied > ~/copyhomesource; rm -f ~/copyhomedestination")
+	assert.Nil(t, runError, "creating the test file returned an error")
+
+	_, copyError := CopyWithOptions(source, "~/copyhomesource", destination, "~/copyhomedestination",
+		CopyOptions{NoOverwrite: true})
+	assert.Nil(t, copyError, "copying in the home directories returned an error")
+	catResponse, catError := destination.Run("cat ~/copyhomedestination")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "copied\n", catResponse.StdOut.String())
+
+	_, copyError = CopyWithOptions(source, "~/copyhomesource", destination, "~/copyhomedestination",
+		CopyOptions{NoOverwrite: true})
+	assert.NotNil(t, copyError, "the existing file in the home directory should be found with NoOverwrite")
+}
@@ -0,0 +1,218 @@
+package gosher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lines of context around the changes in a unified diff.
+const diffContextLines = 3
+
+// An operation of a line diff: ' ' keeps, '-' deletes and '+' inserts a line.
+type diffLine struct {
+	operation byte
+	text      string
+}
+
+// Returns the unified diff between two versions of a file, empty if they are equal.
+func unifiedDiff(filePath string, oldContent string, newContent string) string {
+	if oldContent == newContent {
+		return ""
+	}
+	lines := diffLines(splitLines(oldContent), splitLines(newContent))
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n", strings.TrimPrefix(filePath, "/"), strings.TrimPrefix(filePath, "/"))
+	for start := 0; start < len(lines); {
+		// find the next change and extend the hunk while changes are close enough
+		first := start
+		for first < len(lines) && lines[first].operation == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for i := first; i < len(lines); i++ {
+			if lines[i].operation != ' ' {
+				last = i
+			} else if i-last > 2*diffContextLines {
+				break
+			}
+		}
+		hunkStart := first - diffContextLines
+		if hunkStart < start {
+			hunkStart = start
+		}
+		hunkEnd := last + diffContextLines + 1
+		if hunkEnd > len(lines) {
+			hunkEnd = len(lines)
+		}
+		writeDiffHunk(&diff, lines, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return diff.String()
+}
+
+func writeDiffHunk(diff *strings.Builder, lines []diffLine, start int, end int) {
+	// line numbers of the hunk in the old and the new file
+	oldLine, newLine := 1, 1
+	for _, line := range lines[:start] {
+		if line.operation != '+' {
+			oldLine++
+		}
+		if line.operation != '-' {
+			newLine++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, line := range lines[start:end] {
+		if line.operation != '+' {
+			oldCount++
+		}
+		if line.operation != '-' {
+			newCount++
+		}
+	}
+	// an empty range starts at the line before it
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+	fmt.Fprintf(diff, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+	for _, line := range lines[start:end] {
+		diff.WriteByte(line.operation)
+		if strings.HasSuffix(line.text, "\n") {
+			diff.WriteString(line.text)
+		} else {
+			diff.WriteString(line.text + "\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Splits content into lines keeping their newlines.
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Computes the shortest edit script from oldLines to newLines with the linear space
+// variant of the Myers algorithm, which splits the scripts at their middle snakes.
+func diffLines(oldLines []string, newLines []string) []diffLine {
+	lines := appendDiffLines(make([]diffLine, 0, len(oldLines)+len(newLines)), oldLines, newLines)
+	// the deletions of every change come before its insertions
+	for start := 0; start < len(lines); start++ {
+		if lines[start].operation == ' ' {
+			continue
+		}
+		end := start
+		for end < len(lines) && lines[end].operation != ' ' {
+			end++
+		}
+		change := lines[start:end]
+		sort.SliceStable(change, func(i int, j int) bool {
+			return change[i].operation == '-' && change[j].operation == '+'
+		})
+		start = end
+	}
+	return lines
+}
+
+func appendDiffLines(lines []diffLine, oldLines []string, newLines []string) []diffLine {
+	// the common prefix and suffix are kept as they are
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		lines = append(lines, diffLine{' ', oldLines[prefix]})
+		prefix++
+	}
+	oldLines, newLines = oldLines[prefix:], newLines[prefix:]
+	suffix := 0
+	for suffix < len(oldLines) && suffix < len(newLines) &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+	common := oldLines[len(oldLines)-suffix:]
+	oldLines, newLines = oldLines[:len(oldLines)-suffix], newLines[:len(newLines)-suffix]
+
+	switch {
+	case len(oldLines) == 0:
+		for _, line := range newLines {
+			lines = append(lines, diffLine{'+', line})
+		}
+	case len(newLines) == 0:
+		for _, line := range oldLines {
+			lines = append(lines, diffLine{'-', line})
+		}
+	default:
+		x, y, u, v := middleSnake(oldLines, newLines)
+		lines = appendDiffLines(lines, oldLines[:x], newLines[:y])
+		for _, line := range oldLines[x:u] {
+			lines = append(lines, diffLine{' ', line})
+		}
+		lines = appendDiffLines(lines, oldLines[u:], newLines[v:])
+	}
+	for _, line := range common {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// Returns the snake from (x, y) to (u, v) in the middle of a shortest edit script,
+// found by searching from both ends until the forward and the backward paths overlap.
+// Only the furthest reaching paths of the current edit distance are kept.
+func middleSnake(oldLines []string, newLines []string) (x int, y int, u int, v int) {
+	n, m := len(oldLines), len(newLines)
+	delta := n - m
+	maxDepth := (n + m + 1) / 2
+	offset := maxDepth + 1
+	// forward[k] is the furthest x on diagonal k = x - y from the start,
+	// backward[k] the furthest distance from the end on diagonal k = (n - x) - (m - y)
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for d := 0; d <= maxDepth; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || k != d && forward[offset+k-1] < forward[offset+k+1] {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			startX, startY := x, y
+			for x < n && y < m && oldLines[x] == newLines[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			backwardK := delta - k
+			if delta%2 != 0 && backwardK >= -(d-1) && backwardK <= d-1 && x >= n-backward[offset+backwardK] {
+				return startX, startY, x, y
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var reverseX int
+			if k == -d || k != d && backward[offset+k-1] < backward[offset+k+1] {
+				reverseX = backward[offset+k+1]
+			} else {
+				reverseX = backward[offset+k-1] + 1
+			}
+			reverseY := reverseX - k
+			startX, startY := reverseX, reverseY
+			for reverseX < n && reverseY < m && oldLines[n-1-reverseX] == newLines[m-1-reverseY] {
+				reverseX++
+				reverseY++
+			}
+			backward[offset+k] = reverseX
+			forwardK := delta - k
+			if delta%2 == 0 && forwardK >= -d && forwardK <= d && forward[offset+forwardK] >= n-reverseX {
+				return n - reverseX, m - reverseY, n - startX, m - startY
+			}
+		}
+	}
+	// unreachable, the paths overlap once d reaches half of the edit distance
+	return 0, 0, 0, 0
+}
@@ -0,0 +1,41 @@
+package gosher
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, "", unifiedDiff("/etc/hosts", "a\n", "a\n"), "equal contents should have no diff")
+	expected := "--- a/etc/hosts\n+++ b/etc/hosts\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n"
+	assert.Equal(t, expected, unifiedDiff("/etc/hosts", "a\nb\nc\n", "a\nx\nc\n"))
+	expected = "--- a/f\n+++ b/f\n@@ -1,1 +1,2 @@\n-a\n\\ No newline at end of file\n+a\n+b\n"
+	assert.Equal(t, expected, unifiedDiff("f", "a", "a\nb\n"))
+}
+
+func TestDiffLinesOfChangedFile(t *testing.T) {
+	var oldContent, newContent strings.Builder
+	for i := 0; i < 6000; i++ {
+		fmt.Fprintf(&oldContent, "old %d\n", i)
+		fmt.Fprintf(&newContent, "new %d\n", i)
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	lines := diffLines(splitLines(oldContent.String()), splitLines(newContent.String()))
+	runtime.ReadMemStats(&after)
+	assert.Len(t, lines, 12000, "every line should be deleted and inserted")
+	assert.Equal(t, diffLine{'-', "old 0\n"}, lines[0], "the deletions should come first")
+	assert.Equal(t, diffLine{'+', "new 0\n"}, lines[6000], "the insertions should follow the deletions")
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 16<<20, "the diff should use linear space")
+
+	lines = diffLines(splitLines("a\nb\nc\nd\n"), splitLines("b\nx\nd\ne\n"))
+	assert.Equal(t, []diffLine{{'-', "a\n"}, {' ', "b\n"}, {'-', "c\n"}, {'+', "x\n"}, {' ', "d\n"}, {'+', "e\n"}}, lines)
+}
+
+func TestContentDiffBinary(t *testing.T) {
+	assert.Equal(t, "Binary files a/bin/tool and b/bin/tool differ\n",
+		contentDiff("/bin/tool", []byte{0, 1}, []byte{0, 2}), "binary files should only be reported as different")
+}
@@ -1,16 +1,15 @@
 package gosher
 
 import (
-	"bufio"
 	"errors"
+	"github.com/lyuboraykov/gosher/scp"
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 )
 
-func (s *SshClient) download(remotePath string, localPath string) (*SshResponse, error) {
+func (s *SshClient) download(remotePath string, localPath string, meter *transferMeter,
+	options TransferOptions) (*SshResponse, error) {
 	localPathInfo, err := os.Stat(localPath)
 	destinationDirectory := localPath
 	var useSpecifiedFilename bool
@@ -19,6 +18,7 @@ func (s *SshClient) download(remotePath string, localPath string) (*SshResponse,
 			return nil, err
 		} else {
 			// OK - create file/dir
+			destinationDirectory = filepath.Dir(localPath)
 			useSpecifiedFilename = true
 		}
 	} else if localPathInfo.IsDir() {
@@ -30,205 +30,141 @@ func (s *SshClient) download(remotePath string, localPath string) (*SshResponse,
 		useSpecifiedFilename = true
 	}
 	// from-scp
-	response := NewSshResponse(s.Address, &s.session)
+	response := new(SshResponse)
+	response.Address = s.Address
+	s.session.Stderr = &response.StdErr
 
-	if err != nil {
-		return response, err
+	if options.selectsFolderContents() {
+		plan, err := s.planFolderDownload(remotePath, options)
+		if err != nil {
+			return response, err
+		}
+		if plan != nil {
+			destinationPath := filepath.Join(localPath, filepath.Base(remotePath))
+			if useSpecifiedFilename {
+				destinationPath = localPath
+			}
+			return response, s.downloadPlan(remotePath, destinationPath, plan, meter)
+		}
 	}
-	errorChannel := make(chan error)
-	go s.manageDownloads(errorChannel, destinationDirectory, useSpecifiedFilename, localPath)
-	remoteOpts := "-fr"
-	err = s.session.Run("/usr/bin/scp " + remoteOpts + " " + remotePath)
-	return response, err
-}
 
-func (s *SshClient) manageDownloads(errorChannel chan error, destinationDirectory string,
-	useSpecifiedFilename bool, localPath string) {
 	inPipe, err := s.session.StdinPipe()
 	if err != nil {
-		errorChannel <- err
-		return
+		return response, err
 	}
-	defer inPipe.Close()
 	outPipe, err := s.session.StdoutPipe()
 	if err != nil {
-		errorChannel <- err
-		return
+		return response, err
 	}
-	err = sendByte(inPipe, 0)
-	if err != nil {
-		errorChannel <- err
-		return
+	remoteOpts := "-fr"
+	if err = s.session.Start("/usr/bin/scp " + remoteOpts + " " + shellQuote(remotePath)); err != nil {
+		return response, err
 	}
-	// use a scanner for processing individual commands, but not files themselves
-	scanner := bufio.NewScanner(outPipe)
-	more := true
-	isFirstCommand := true
-	for more {
-		commandArray := make([]byte, 1)
-		commandLength, err := outPipe.Read(commandArray)
+	fileErrors, err := receiveDownload(scp.NewSink(outPipe, inPipe), destinationDirectory, useSpecifiedFilename,
+		localPath, meter)
+	inPipe.Close()
+	// scp exits with 1 if some of the files failed, these are reported instead
+	waitErr := s.session.Wait()
+	if err == nil && len(fileErrors) > 0 {
+		err = joinErrors(fileErrors)
+	}
+	if err == nil {
+		err = waitErr
+	}
+	return response, err
+}
+
+// Receives the files and folders sent by scp -f into destinationDirectory,
+// the first of them is named after localPath if useSpecifiedFilename is set.
+// Returns the errors of the files which were skipped and an error if the download was aborted.
+func receiveDownload(sink *scp.Sink, destinationDirectory string, useSpecifiedFilename bool,
+	localPath string, meter *transferMeter) ([]error, error) {
+	var fileErrors []error
+	// the folders being received, their times are set when they end
+	var directories []scp.Record
+	isFirstRecord := true
+	for {
+		record, err := sink.Next()
+		if err == io.EOF {
+			return fileErrors, nil
+		}
+		if scp.IsWarning(err) {
+			fileErrors = append(fileErrors, err)
+			continue
+		}
 		if err != nil {
-			if err == io.EOF {
-				// no problem.
-			} else {
-				errorChannel <- err
-			}
-			return
+			return fileErrors, err
 		}
-		if commandLength < 1 {
-			errorChannel <- errors.New("Error reading next byte from standard input")
-			return
+		// use the specified filename from the destination (only for top-level item)
+		name := record.Name
+		if useSpecifiedFilename && isFirstRecord {
+			name = filepath.Base(localPath)
 		}
-		command := commandArray[0]
-		switch command {
-		case 0x0:
-			// continue
-		case 'E':
-			// E command: go back out of dir
-			destinationDirectory = filepath.Dir(destinationDirectory)
-			err = sendByte(inPipe, 0)
-			if err != nil {
-				errorChannel <- err
-				return
+		isFirstRecord = false
+		switch record.Type {
+		case scp.File:
+			err = receiveFile(sink, record, filepath.Join(destinationDirectory, name), meter)
+			if scp.IsFileError(err) {
+				fileErrors = append(fileErrors, err)
+				err = nil
 			}
-		case 0xA:
-			// 0xA command: end?
-			err = sendByte(inPipe, 0)
-			if err != nil {
-				errorChannel <- err
-				return
+		case scp.Directory:
+			directoryPath := filepath.Join(destinationDirectory, name)
+			if mkdirErr := os.MkdirAll(directoryPath, record.Mode); mkdirErr != nil {
+				// the source skips the contents of a rejected folder
+				fileErrors = append(fileErrors, mkdirErr)
+				err = sink.Reject(name + ": " + mkdirErr.Error())
+				break
 			}
-			return
-		default:
-			scanner.Scan()
-			err = scanner.Err()
-			if err != nil {
-				if err == io.EOF {
-					//  no problem.
-				} else {
-					errorChannel <- err
-				}
-				return
+			directories = append(directories, record)
+			destinationDirectory = directoryPath
+			err = sink.Ack()
+		case scp.EndDirectory:
+			if len(directories) == 0 {
+				return fileErrors, errors.New("Unexpected end of folder")
 			}
-			//  first line
-			fullCommand := scanner.Text()
-			//  remainder, split by spaces
-			splitCommands := strings.SplitN(fullCommand, " ", 3)
-
-			switch command {
-			case 0x1:
-				errorChannel <- errors.New(fullCommand[1:])
-				return
-			case 'D', 'C':
-				if err = s.manageWrites(splitCommands, inPipe, command, isFirstCommand,
-					outPipe, destinationDirectory, useSpecifiedFilename, localPath, commandLength); err != nil {
-					errorChannel <- err
-					return
-				}
-			default:
-				return
+			if timesErr := setTimes(destinationDirectory, directories[len(directories)-1]); timesErr != nil {
+				fileErrors = append(fileErrors, timesErr)
 			}
+			directories = directories[:len(directories)-1]
+			destinationDirectory = filepath.Dir(destinationDirectory)
+			err = sink.Ack()
 		}
-		isFirstCommand = false
-	}
-	err = inPipe.Close()
-	if err != nil {
-		errorChannel <- err
-		return
-	}
-}
-
-func (s *SshClient) manageWrites(splitCommands []string, inPipe io.WriteCloser, command byte, isFirstCommand bool,
-	outPipe io.Reader, destinationDirectory string, useSpecifiedFilename bool, localPath string, commandLength int) error {
-	mode, err := strconv.ParseInt(splitCommands[0], 8, 32)
-	if err != nil {
-		return err
-	}
-	sizeUint, err := strconv.ParseUint(splitCommands[1], 10, 64)
-	commandSize := int64(sizeUint)
-	if err != nil {
-		return err
-	}
-	rcvFilename := splitCommands[2]
-	var filename string
-	// use the specified filename from the destination (only for top-level item)
-	if useSpecifiedFilename && isFirstCommand {
-		filename = filepath.Base(localPath)
-	} else {
-		filename = rcvFilename
-	}
-	err = sendByte(inPipe, 0)
-	if err != nil {
-		return err
-	}
-	if command == 'C' {
-		// C command - file
-		if err = s.writeFileFromPipe(destinationDirectory, filename, inPipe,
-			commandLength, commandSize, outPipe); err != nil {
-			return err
-		}
-	} else {
-		// D command (directory)
-		thisDstFile := filepath.Join(destinationDirectory, filename)
-		fileMode := os.FileMode(uint32(mode))
-		err = os.MkdirAll(thisDstFile, fileMode)
 		if err != nil {
-			return err
+			return fileErrors, err
 		}
-		destinationDirectory = thisDstFile
 	}
-	return nil
 }
 
-func (s *SshClient) writeFileFromPipe(destinationDirectory string, filename string,
-	inPipe io.WriteCloser, commandLength int, commandSize int64, outPipe io.Reader) error {
-	thisLocalPath := filepath.Join(destinationDirectory, filename)
-	tot := int64(0)
-
-	fileWriter, err := os.Create(thisLocalPath)
+// Receives the content of a file record into localPath, a file which can't be created is rejected.
+// Returns an error of the file which doesn't stop the download as an scp.ContentError or a warning.
+func receiveFile(sink *scp.Sink, record scp.Record, localPath string, meter *transferMeter) error {
+	meter.addTotal(record.Size)
+	meter.startFile(localPath)
+	fileWriter, err := os.Create(localPath)
 	if err != nil {
-		return err
-	}
-	defer fileWriter.Close()
-
-	// buffered by 4096 bytes
-	bufferSize := int64(4096)
-	for tot < commandSize {
-		if bufferSize > commandSize-tot {
-			bufferSize = commandSize - tot
-		}
-		b := make([]byte, bufferSize)
-		commandLength, err = outPipe.Read(b)
-		if err != nil {
-			return err
-		}
-		tot += int64(commandLength)
-		// write to file
-		_, err = fileWriter.Write(b[:commandLength])
-		if err != nil {
-			return err
+		if rejectErr := sink.Reject(record.Name + ": " + err.Error()); rejectErr != nil {
+			return rejectErr
 		}
+		return &scp.ContentError{Name: record.Name, Err: err}
 	}
-	// close file writer & check error
-	err = fileWriter.Close()
-	if err != nil {
-		return err
+	err = sink.ReceiveFile(record, meter.writer(fileWriter))
+	if closeErr := fileWriter.Close(); err == nil && closeErr != nil {
+		err = &scp.ContentError{Name: record.Name, Err: closeErr}
 	}
-	// get next byte from channel reader
-	nextByte := make([]byte, 1)
-	_, err = outPipe.Read(nextByte)
-	if err != nil {
-		return err
-	}
-	// send null-byte back
-	_, err = inPipe.Write([]byte{0})
-	if err != nil {
-		return err
+	if err == nil {
+		if timesErr := setTimes(localPath, record); timesErr != nil {
+			err = &scp.ContentError{Name: record.Name, Err: timesErr}
+		}
 	}
-	return nil
+	meter.finishFile()
+	return err
 }
 
-func sendByte(w io.Writer, val byte) error {
-	_, err := w.Write([]byte{val})
-	return err
+// Sets the times of a record which was preceded by a times record.
+func setTimes(localPath string, record scp.Record) error {
+	if record.ModTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(localPath, record.AccessTime, record.ModTime)
 }
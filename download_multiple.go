@@ -0,0 +1,202 @@
+package gosher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DownloadResult - the result of downloading a single remote file/folder with DownloadMultiple.
+// Pattern is the requested path or glob which matched RemotePath,
+// Err is the error which occured while downloading it, if any.
+type DownloadResult struct {
+	Pattern    string
+	RemotePath string
+	LocalPath  string
+	Err        error
+}
+
+// A remote path matched by one of the patterns of DownloadMultiple.
+type remoteMatch struct {
+	patternIndex int
+	isDirectory  bool
+	path         string
+}
+
+// Downloads multiple files/folders from the remote machine in a single session.
+// The remote paths can contain the *, ? and [...] wildcards which are expanded on the
+// remote machine, everything else in them is taken literally except a leading ~,
+// the home directory of the remote user.
+// Every matched file/folder is saved in localDir under its name.
+// Returns a DownloadResult for every matched path and for every pattern which didn't match
+// anything, and an error if the download couldn't be performed at all.
+func (s *SshClient) DownloadMultiple(remotePaths []string, localDir string) ([]*DownloadResult, error) {
+	if err := os.MkdirAll(localDir, 0755); err != nil {
+		return nil, err
+	}
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	matches, err := s.expandRemotePaths(remotePaths)
+	if err != nil {
+		return nil, err
+	}
+	plan := planMultipleDownload(remotePaths, matches, localDir)
+	meter := s.newTransferMeter(s.ProgressCallback)
+	for _, result := range plan.folders {
+		folderPlan, err := s.planFolderDownload(result.RemotePath, TransferOptions{})
+		if err == nil && folderPlan == nil {
+			// it was removed or replaced since the patterns were expanded
+			err = errors.New(result.RemotePath + ": Not a directory")
+		}
+		if err == nil {
+			err = s.downloadPlan(result.RemotePath, result.LocalPath, folderPlan, meter)
+		}
+		result.Err = err
+	}
+	fileRemotePaths := make([]string, len(plan.files))
+	fileLocalPaths := make([]string, len(plan.files))
+	for i, result := range plan.files {
+		fileRemotePaths[i] = result.RemotePath
+		fileLocalPaths[i] = result.LocalPath
+	}
+	fileErrors, err := s.downloadFiles(fileRemotePaths, fileLocalPaths, meter)
+	for i, result := range plan.files {
+		result.Err = fileErrors[i]
+	}
+	return plan.results, err
+}
+
+// The DownloadResults of DownloadMultiple before the download, the files and folders
+// which have to be downloaded are also in results.
+type multipleDownloadPlan struct {
+	results []*DownloadResult
+	files   []*DownloadResult
+	folders []*DownloadResult
+}
+
+// Plans where every match is downloaded to in localDir, a match whose name was already
+// downloaded by an earlier one and a pattern which didn't match anything have an error.
+func planMultipleDownload(patterns []string, matches []remoteMatch, localDir string) *multipleDownloadPlan {
+	plan := &multipleDownloadPlan{}
+	matchedPatterns := make([]bool, len(patterns))
+	localPaths := make(map[string]bool)
+	for _, match := range matches {
+		matchedPatterns[match.patternIndex] = true
+		result := &DownloadResult{
+			Pattern:    patterns[match.patternIndex],
+			RemotePath: match.path,
+			LocalPath:  filepath.Join(localDir, path.Base(match.path)),
+		}
+		plan.results = append(plan.results, result)
+		switch {
+		case localPaths[result.LocalPath]:
+			result.Err = errors.New("Another file was already downloaded to " + result.LocalPath)
+		case match.isDirectory:
+			plan.folders = append(plan.folders, result)
+		default:
+			plan.files = append(plan.files, result)
+		}
+		localPaths[result.LocalPath] = true
+	}
+	for i, matched := range matchedPatterns {
+		if !matched {
+			plan.results = append(plan.results, &DownloadResult{
+				Pattern: patterns[i],
+				Err:     errors.New(patterns[i] + ": No such file or directory"),
+			})
+		}
+	}
+	return plan
+}
+
+// Expands the patterns on the remote machine.
+func (s *SshClient) expandRemotePaths(patterns []string) ([]remoteMatch, error) {
+	var command strings.Builder
+	for i, pattern := range patterns {
+		// the leading ~ would be taken literally by quoteGlob
+		pattern, err := s.expandRemotePath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&command, `for p in %s; do if [ -d "$p" ]; then printf '%d\td\t%%s\0' "$p"; `+
+			`elif [ -e "$p" ]; then printf '%d\tf\t%%s\0' "$p"; fi; done; `, quoteGlob(pattern), i, i)
+	}
+	command.WriteString("true")
+	expansion, err := s.output(command.String())
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while expanding the remote paths: " + err.Error())
+	}
+	return parseRemoteMatches(expansion, len(patterns))
+}
+
+// Parses the NUL separated "index\ttype\tpath" entries printed by the expansion of the patterns.
+func parseRemoteMatches(expansion string, patternCount int) ([]remoteMatch, error) {
+	var matches []remoteMatch
+	for _, entry := range strings.Split(expansion, "\x00") {
+		fields := strings.SplitN(entry, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		patternIndex, err := strconv.Atoi(fields[0])
+		if err != nil || patternIndex >= patternCount {
+			return nil, NewSshConnectionError("Unexpected expansion of the remote paths: " + entry)
+		}
+		matches = append(matches, remoteMatch{
+			patternIndex: patternIndex,
+			isDirectory:  fields[1] == "d",
+			path:         fields[2],
+		})
+	}
+	return matches, nil
+}
+
+// Quotes a remote path so that the shell expands only its *, ? and [...] wildcards.
+// Bracket expressions with anything but letters, digits, '.', '_', '-', '!' and '^' are taken literally.
+func quoteGlob(pattern string) string {
+	var quoted, literal strings.Builder
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			quoted.WriteString(shellQuote(literal.String()))
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+			flushLiteral()
+			quoted.WriteByte(pattern[i])
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end > 0 && isSafeBracketExpression(pattern[i+1:i+1+end]) {
+				flushLiteral()
+				quoted.WriteString(pattern[i : i+end+2])
+				i += end + 1
+			} else {
+				literal.WriteByte('[')
+			}
+		default:
+			literal.WriteByte(pattern[i])
+		}
+	}
+	flushLiteral()
+	return quoted.String()
+}
+
+func isSafeBracketExpression(expression string) bool {
+	for _, c := range expression {
+		isAlphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphanumeric && !strings.ContainsRune("._-!^", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuoteGlob(t *testing.T) {
+	assert.Equal(t, "'/var/log/app/'*'.log'", quoteGlob("/var/log/app/*.log"), "wildcard should stay unquoted")
+	assert.Equal(t, "'/data/file'[0-9]", quoteGlob("/data/file[0-9]"), "bracket expression should stay unquoted")
+	assert.Equal(t, "'/tmp/$(reboot)['", quoteGlob("/tmp/$(reboot)["), "command substitution should be quoted")
+	assert.Equal(t, "'/tmp/[;reboot]'", quoteGlob("/tmp/[;reboot]"), "unsafe bracket expression should be quoted")
+	assert.Equal(t, `'/it'\''s'?`, quoteGlob("/it's?"), "single quotes should be escaped")
+}
+
+func TestParseRemoteMatches(t *testing.T) {
+	matches, err := parseRemoteMatches("0\tf\t/var/log/app/a.log\x000\tf\t/var/log/app/b\tc.log\x001\td\t/etc/app\x00", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []remoteMatch{
+		{patternIndex: 0, path: "/var/log/app/a.log"},
+		{patternIndex: 0, path: "/var/log/app/b\tc.log"},
+		{patternIndex: 1, isDirectory: true, path: "/etc/app"},
+	}, matches, "names with tabs should be kept")
+	_, err = parseRemoteMatches("2\tf\t/etc/passwd\x00", 2)
+	assert.NotNil(t, err, "a match of an unknown pattern should fail")
+}
+
+func TestPlanMultipleDownload(t *testing.T) {
+	patterns := []string{"/var/log/app/*.log", "/etc/app", "/srv/*.log", "/missing"}
+	plan := planMultipleDownload(patterns, []remoteMatch{
+		{patternIndex: 0, path: "/var/log/app/a.log"},
+		{patternIndex: 1, isDirectory: true, path: "/etc/app"},
+		{patternIndex: 2, path: "/srv/a.log"},
+	}, filepath.Join("downloads", "web1"))
+	if !assert.Equal(t, 4, len(plan.results)) {
+		return
+	}
+	assert.Equal(t, filepath.Join("downloads", "web1", "a.log"), plan.results[0].LocalPath)
+	assert.Equal(t, []*DownloadResult{plan.results[0]}, plan.files)
+	assert.Equal(t, []*DownloadResult{plan.results[1]}, plan.folders)
+	assert.Equal(t, filepath.Join("downloads", "web1", "app"), plan.results[1].LocalPath)
+	assert.Equal(t, "/srv/*.log", plan.results[2].Pattern)
+	assert.NotNil(t, plan.results[2].Err, "a second file with the same name shouldn't overwrite the first one")
+	assert.Equal(t, "/missing", plan.results[3].Pattern)
+	assert.NotNil(t, plan.results[3].Err, "a pattern which matched nothing should be reported")
+}
+
+func TestDownloadMultipleFromHome(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("mkdir -p ~/globdir && echo a > ~/globdir/a.log && echo b > ~/globdir/b.log")
+	assert.Nil(t, runError, "creating the test files returned an error")
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	results, downloadError := s.DownloadMultiple([]string{"~/globdir/*.log"}, dir)
+	assert.Nil(t, downloadError, "DownloadMultiple returned an error")
+	assert.Len(t, results, 2, "the wildcard in the home directory should match both files")
+	for _, result := range results {
+		assert.Nil(t, result.Err, "downloading "+result.RemotePath+" returned an error")
+	}
+	content, readErr := ioutil.ReadFile(filepath.Join(dir, "b.log"))
+	assert.Nil(t, readErr, "reading the downloaded file returned an error")
+	assert.Equal(t, "b\n", string(content))
+}
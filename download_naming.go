@@ -0,0 +1,164 @@
+package gosher
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Templates of MultipleHostsSshClient.DownloadNaming.
+// NamingByIndex suffixes the local path with the index of the host (file0, file1),
+// NamingByAddress with its address (file.10.0.0.1), NamingByAlias with its alias
+// and NamingBySubdirectory downloads into a subdirectory per host named by its alias (file/web-1/).
+const (
+	NamingByIndex        = "{{.Path}}{{.Index}}"
+	NamingByAddress      = "{{.Path}}.{{.Address}}"
+	NamingByAlias        = "{{.Path}}.{{.Alias}}"
+	NamingBySubdirectory = "{{.Path}}/{{.Alias}}/"
+)
+
+// DownloadNamingData - the data a DownloadNaming template is executed with.
+// Path is the local path passed to Download, Dir and Base are its directory and last element.
+// Alias is the address if the host has no alias.
+type DownloadNamingData struct {
+	Path    string
+	Dir     string
+	Base    string
+	Address string
+	Alias   string
+	Index   int
+}
+
+// Returns the local path the host with the given index downloads localPath to.
+func (msc *MultipleHostsSshClient) downloadPath(index int, localPath string) (string, error) {
+	naming := msc.DownloadNaming
+	if naming == "" {
+		naming = NamingByIndex
+	}
+	namingTemplate, err := template.New("naming").Parse(naming)
+	if err != nil {
+		return "", err
+	}
+	host := msc.Hosts[index]
+	var rendered bytes.Buffer
+	err = namingTemplate.Execute(&rendered, DownloadNamingData{
+		Path:    localPath,
+		Dir:     filepath.Dir(localPath),
+		Base:    filepath.Base(localPath),
+		Address: host.Client.Address,
+		Alias:   host.Name(),
+		Index:   index,
+	})
+	if err != nil {
+		return "", err
+	}
+	hostPath := rendered.String()
+	if strings.HasSuffix(hostPath, "/") {
+		return hostPath, os.MkdirAll(hostPath, 0755)
+	}
+	return hostPath, os.MkdirAll(filepath.Dir(hostPath), 0755)
+}
+
+// Downloads the same file from all hosts of the MultipleHostsSshClient's list and writes them
+// one after another to writer in the order of the hosts, every line prefixed with the
+// alias (or address) of the host it comes from, e.g. "web-1: GET /index.html".
+// The sshResponse of every host is passed via its channels once its file is written.
+func (msc *MultipleHostsSshClient) DownloadMerged(remotePath string, writer io.Writer) {
+	go msc.writeMerged(writer, msc.mergedDownload(remotePath), msc.deliver)
+}
+
+// Downloads the same file from all hosts and writes them to writer like DownloadMerged,
+// blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) DownloadMergedAll(remotePath string, writer io.Writer) []HostResult {
+	results := make([]HostResult, len(msc.Hosts))
+	msc.writeMerged(writer, msc.mergedDownload(remotePath), func(result HostResult) {
+		results[result.Index] = result
+	})
+	return results
+}
+
+// Returns the function which downloads remotePath from a host to a temporary file.
+func (msc *MultipleHostsSshClient) mergedDownload(remotePath string) func(client *SshClient) (*os.File, *SshResponse, error) {
+	return func(client *SshClient) (*os.File, *SshResponse, error) {
+		return client.downloadToTemporaryFile(remotePath)
+	}
+}
+
+// Downloads a file from every host with download and writes the files to writer in the order
+// of the hosts, each one as soon as all hosts before it have finished, see DownloadMerged.
+// onResult is called with the result of every host once its file is written.
+func (msc *MultipleHostsSshClient) writeMerged(writer io.Writer,
+	download func(client *SshClient) (*os.File, *SshResponse, error), onResult func(result HostResult)) {
+	files := make([]*os.File, len(msc.Hosts))
+	task := func(index int, client *SshClient) (*SshResponse, error) {
+		file, response, err := download(client)
+		if err != nil && file != nil {
+			file.Close()
+			os.Remove(file.Name())
+			file = nil
+		}
+		// the file is read once the result of the host is received
+		files[index] = file
+		return response, err
+	}
+	finished := make([]*HostResult, len(msc.Hosts))
+	next := 0
+	for result := range msc.execute(task) {
+		result := result
+		finished[result.Index] = &result
+		for ; next < len(finished) && finished[next] != nil; next++ {
+			current := finished[next]
+			if file := files[next]; file != nil {
+				if current.Err == nil {
+					current.Err = writePrefixedLines(writer, file, msc.Hosts[next].Name()+": ")
+				}
+				file.Close()
+				os.Remove(file.Name())
+			}
+			onResult(*current)
+		}
+	}
+}
+
+// Downloads remotePath to a temporary file which is rewound for reading.
+func (s *SshClient) downloadToTemporaryFile(remotePath string) (*os.File, *SshResponse, error) {
+	file, err := ioutil.TempFile("", "gosher")
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := s.downloadWithProgress(remotePath, file.Name(), TransferOptions{}, s.ProgressCallback)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	return file, response, err
+}
+
+// Copies the lines of reader to writer prefixed with prefix,
+// a newline is added to the last line if it has none.
+func writePrefixedLines(writer io.Writer, reader io.Reader, prefix string) error {
+	lines := bufio.NewReader(reader)
+	for {
+		line, err := lines.ReadString('\n')
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if _, writeErr := fmt.Fprint(writer, prefix+line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package gosher
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrefixedLines(t *testing.T) {
+	var merged bytes.Buffer
+	err := writePrefixedLines(&merged, strings.NewReader("first\nsecond"), "web-1: ")
+	assert.Nil(t, err)
+	assert.Equal(t, "web-1: first\nweb-1: second\n", merged.String(), "every line should be prefixed")
+}
+
+func TestDownloadPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	msc := NewMultipleHostsSshClient(
+		&Host{Client: newPasswordAuthenticatedClient("10.0.0.1", "root", "")},
+		&Host{Client: newPasswordAuthenticatedClient("10.0.0.2", "root", ""), Alias: "web-2"})
+	localPath := filepath.Join(dir, "app.log")
+	hostPath, err := msc.downloadPath(1, localPath)
+	assert.Nil(t, err)
+	assert.Equal(t, localPath+"1", hostPath, "the index should be the default suffix")
+	msc.DownloadNaming = NamingByAlias
+	hostPath, _ = msc.downloadPath(0, localPath)
+	assert.Equal(t, localPath+".10.0.0.1", hostPath, "the address should be used without an alias")
+	msc.DownloadNaming = NamingBySubdirectory
+	hostPath, _ = msc.downloadPath(1, localPath)
+	assert.Equal(t, localPath+"/web-2/", hostPath)
+	assert.DirExists(t, hostPath, "the subdirectory should be created")
+}
+
+func TestWriteMerged(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d")
+	download := func(client *SshClient) (*os.File, *SshResponse, error) {
+		file, _ := ioutil.TempFile("", "gosher")
+		if client.Address == "a" {
+			// the first host finishes last
+			time.Sleep(20 * time.Millisecond)
+		}
+		if client.Address == "c" {
+			return file, nil, errors.New("no such file")
+		}
+		file.WriteString("line of " + client.Address)
+		file.Seek(0, 0)
+		return file, &SshResponse{}, nil
+	}
+	var merged bytes.Buffer
+	var order []int
+	msc.writeMerged(&merged, download, func(result HostResult) {
+		order = append(order, result.Index)
+	})
+	assert.Equal(t, "a: line of a\nb: line of b\nd: line of d\n", merged.String(),
+		"the files should be written in the order of the hosts")
+	assert.Equal(t, []int{0, 1, 2, 3}, order)
+
+	merged.Reset()
+	msc.Serial = "1"
+	msc.Hosts[0].Alias = "web-1"
+	var results []HostResult
+	msc.writeMerged(&merged, download, func(result HostResult) {
+		results = append(results, result)
+	})
+	assert.Equal(t, "web-1: line of a\nb: line of b\n", merged.String())
+	assert.NotNil(t, results[2].Err)
+	assert.True(t, results[3].Skipped(), "the hosts after the failed batch should be skipped")
+}
@@ -0,0 +1,228 @@
+package gosher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lyuboraykov/gosher/scp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The contents of a remote folder selected by the filters and the symlink policy
+// of TransferOptions, paths are slash separated and relative to the folder.
+type folderDownloadPlan struct {
+	directories []string
+	files       []string
+	sizes       []int64
+	// link targets for SymlinkCopyLink
+	links map[string]string
+}
+
+// Reports whether only some of the contents of a folder are transferred
+// or symlinks aren't simply followed.
+func (options TransferOptions) selectsFolderContents() bool {
+	return len(options.Include) > 0 || len(options.Exclude) > 0 || options.SymlinkPolicy != SymlinkFollow
+}
+
+// Lists the remote folder and applies the options to its contents.
+// Returns nil if remotePath isn't a folder. Requires GNU find on the remote machine,
+// with SymlinkFollow it skips the links back to a parent folder.
+func (s *SshClient) planFolderDownload(remotePath string, options TransferOptions) (*folderDownloadPlan, error) {
+	filter, err := newPathFilter(options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	findOptions := ""
+	if options.SymlinkPolicy == SymlinkFollow {
+		findOptions = "-L "
+	}
+	quotedPath := shellQuote(remotePath)
+	// the fields are NUL separated, the only byte which can't be in a name
+	listing, err := s.output(fmt.Sprintf(`if [ -d %s ]; then printf 'd\0'; cd %s && find %s. -mindepth 1 `+
+		`\( -type d -printf 'd\0%%P\0' \) -o \( -type l -printf 'l\0%%P\0%%l\0' \) `+
+		`-o \( -type f -printf 'f\0%%P\0%%s\0' \) 2>/dev/null; fi; true`, quotedPath, quotedPath, findOptions))
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while listing " + remotePath + ": " + err.Error())
+	}
+	return parseFolderListing(listing, filter, options.SymlinkPolicy)
+}
+
+// Parses the listing of a remote folder: "d" followed by a type ("d", "f" or "l"), a relative path
+// and, for files, the size or, for links, the target of every entry, all terminated by NUL.
+// Returns nil if the listing isn't of a folder.
+func parseFolderListing(listing string, filter *pathFilter, symlinkPolicy int) (*folderDownloadPlan, error) {
+	fields := strings.Split(listing, "\x00")
+	if fields[0] != "d" {
+		return nil, nil
+	}
+	plan := &folderDownloadPlan{
+		links: make(map[string]string),
+	}
+	// with Include patterns only the directories of the included files are created
+	for i := 1; i+1 < len(fields); {
+		entryType, relativePath := fields[i], fields[i+1]
+		i += 2
+		// the size of a file or the target of a link
+		value := ""
+		if entryType == "f" || entryType == "l" {
+			if i >= len(fields) {
+				return nil, errors.New("Unexpected end of the remote folder listing at " + relativePath)
+			}
+			value = fields[i]
+			i++
+		}
+		switch {
+		case entryType == "d" && filter.allowsDirectory(relativePath) && !filter.hasIncludes():
+			plan.directories = append(plan.directories, relativePath)
+		case entryType == "f" && filter.allowsFile(relativePath):
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			plan.files = append(plan.files, relativePath)
+			plan.sizes = append(plan.sizes, size)
+		case entryType == "l" && symlinkPolicy == SymlinkCopyLink && filter.allowsFile(relativePath):
+			plan.links[relativePath] = value
+		}
+	}
+	return plan, nil
+}
+
+// Downloads the planned contents of remotePath to destinationPath.
+func (s *SshClient) downloadPlan(remotePath string, destinationPath string,
+	plan *folderDownloadPlan, meter *transferMeter) error {
+	if err := os.MkdirAll(destinationPath, 0755); err != nil {
+		return err
+	}
+	for _, directory := range plan.directories {
+		if err := os.MkdirAll(filepath.Join(destinationPath, filepath.FromSlash(directory)), 0755); err != nil {
+			return err
+		}
+	}
+	remotePaths := make([]string, len(plan.files))
+	localPaths := make([]string, len(plan.files))
+	for i, relativePath := range plan.files {
+		remotePaths[i] = path.Join(remotePath, relativePath)
+		localPaths[i] = filepath.Join(destinationPath, filepath.FromSlash(relativePath))
+	}
+	fileErrors, err := s.downloadFiles(remotePaths, localPaths, meter)
+	if err != nil {
+		return err
+	}
+	for _, fileErr := range fileErrors {
+		if fileErr != nil {
+			return fileErr
+		}
+	}
+	for relativePath, target := range plan.links {
+		linkPath := filepath.Join(destinationPath, filepath.FromSlash(relativePath))
+		if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+			return err
+		}
+		os.Remove(linkPath)
+		if err := os.Symlink(target, linkPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Downloads every remote file to the local path with the same index,
+// with a single scp for every remoteBatchSize files.
+// Returns the errors of the individual files and an error if the whole download failed.
+func (s *SshClient) downloadFiles(remotePaths []string, localPaths []string,
+	meter *transferMeter) ([]error, error) {
+	fileErrors := make([]error, len(remotePaths))
+	for start := 0; start < len(remotePaths); start += remoteBatchSize {
+		end := start + remoteBatchSize
+		if end > len(remotePaths) {
+			end = len(remotePaths)
+		}
+		err := s.downloadFilesBatch(remotePaths[start:end], localPaths[start:end], fileErrors[start:end], meter)
+		if err != nil {
+			return fileErrors, err
+		}
+	}
+	return fileErrors, nil
+}
+
+func (s *SshClient) downloadFilesBatch(remotePaths []string, localPaths []string, fileErrors []error,
+	meter *transferMeter) error {
+	session, err := s.connection.NewSession()
+	if err != nil {
+		return NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer session.Close()
+	inPipe, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	outPipe, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	quotedPaths := make([]string, len(remotePaths))
+	for i, remotePath := range remotePaths {
+		quotedPaths[i] = shellQuote(remotePath)
+	}
+	if err = session.Start("/usr/bin/scp -f " + strings.Join(quotedPaths, " ")); err != nil {
+		return NewSshConnectionError("There was an error while downloading: " + err.Error())
+	}
+	err = receiveFiles(scp.NewSink(outPipe, inPipe), localPaths, fileErrors, meter)
+	inPipe.Close()
+	if err != nil {
+		return NewSshConnectionError("There was an error while downloading: " + err.Error())
+	}
+	if err = session.Wait(); err != nil {
+		// scp exits with 1 if some of the files failed, these are already reported
+		if _, isExitError := err.(*ssh.ExitError); !isExitError || !hasErrors(fileErrors) {
+			return NewSshConnectionError("There was an error while downloading: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Receives the files sent by scp -f in the order they were requested in.
+// Warnings about individual files are stored in fileErrors and the rest of the files are received,
+// a fatal error aborts the download.
+func receiveFiles(sink *scp.Sink, localPaths []string, fileErrors []error, meter *transferMeter) error {
+	for i, localPath := range localPaths {
+		record, err := sink.Next()
+		if scp.IsWarning(err) {
+			fileErrors[i] = err
+			continue
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if record.Type != scp.File {
+			return errors.New("Unexpected scp record: " + strings.TrimSuffix(scp.FormatRecord(record), "\n"))
+		}
+		if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		if err = receiveFile(sink, record, localPath, meter); scp.IsFileError(err) {
+			fileErrors[i] = err
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasErrors(errs []error) bool {
+	for _, err := range errs {
+		if err != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseFolderListing(t *testing.T) {
+	filter, err := newPathFilter(nil, nil)
+	assert.Nil(t, err)
+	listing := "d\x00d\x00logs\x00f\x00logs/a\tb.log\x0012\x00f\x00 padded \x003\x00" +
+		"f\x00new\nline\x000\x00l\x00current\x00logs\x00"
+	plan, err := parseFolderListing(listing, filter, SymlinkCopyLink)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"logs"}, plan.directories)
+	assert.Equal(t, []string{"logs/a\tb.log", " padded ", "new\nline"}, plan.files,
+		"names with tabs, newlines and surrounding spaces should be kept")
+	assert.Equal(t, []int64{12, 3, 0}, plan.sizes)
+	assert.Equal(t, map[string]string{"current": "logs"}, plan.links)
+
+	plan, err = parseFolderListing("", filter, SymlinkFollow)
+	assert.Nil(t, err)
+	assert.Nil(t, plan, "a file shouldn't be planned as a folder")
+	_, err = parseFolderListing("d\x00f\x00truncated", filter, SymlinkFollow)
+	assert.NotNil(t, err, "a truncated listing should fail")
+}
@@ -0,0 +1,163 @@
+package gosher
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// EditOptions - options of EnsureLineWithOptions and ManagedBlockWithOptions.
+// Create - false by default (the editors fail if the file is missing), if true a missing file
+// is edited as if it was empty and created with Mode if anything is added to it
+// Mode - 0644 by default, the mode of a created file
+// Diff - false by default, if true the Diff of a changed file is reported outside CheckMode too
+type EditOptions struct {
+	Create bool
+	Mode   os.FileMode
+	Diff   bool
+}
+
+// Ensures line is present in the remote file, it is appended if no line of the file equals it.
+// Fails if the file doesn't exist, see EnsureLineWithOptions.
+// The Diff of the change is reported in CheckMode or with EditOptions.Diff.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) EnsureLine(filePath string, line string) (*SshResponse, error) {
+	return s.EnsureLineWithOptions(filePath, line, EditOptions{})
+}
+
+// Ensures line is present in the remote file with the given EditOptions, see EnsureLine.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) EnsureLineWithOptions(filePath string, line string, options EditOptions) (*SshResponse, error) {
+	return s.editFile(filePath, options, func(content string) (string, error) {
+		return ensureLine(content, line), nil
+	})
+}
+
+// Replaces the matches of expression in every line of the remote file with replacement,
+// which can refer to submatches like $1, see regexp.ReplaceAllString. Fails if the file doesn't exist.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) ReplaceRegexp(filePath string, expression string, replacement string) (*SshResponse, error) {
+	compiledExpression, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return s.editFile(filePath, EditOptions{}, func(content string) (string, error) {
+		return replaceRegexp(content, compiledExpression, replacement), nil
+	})
+}
+
+// Ensures the lines between the beginMarker and endMarker lines of the remote file are block,
+// the markers and the block are appended if the file doesn't contain them.
+// An empty block removes the markers and everything between them.
+// Fails if the file doesn't exist, see ManagedBlockWithOptions.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) ManagedBlock(filePath string, beginMarker string, endMarker string,
+	block string) (*SshResponse, error) {
+	return s.ManagedBlockWithOptions(filePath, beginMarker, endMarker, block, EditOptions{})
+}
+
+// Ensures the block between the markers of the remote file with the given EditOptions, see ManagedBlock.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) ManagedBlockWithOptions(filePath string, beginMarker string, endMarker string,
+	block string, options EditOptions) (*SshResponse, error) {
+	return s.editFile(filePath, options, func(content string) (string, error) {
+		return managedBlock(content, beginMarker, endMarker, block), nil
+	})
+}
+
+// Deletes the lines of the remote file matching expression. Fails if the file doesn't exist.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) DeleteLines(filePath string, expression string) (*SshResponse, error) {
+	compiledExpression, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	return s.editFile(filePath, EditOptions{}, func(content string) (string, error) {
+		return deleteLines(content, compiledExpression), nil
+	})
+}
+
+// Applies edit to the content of the remote file.
+func (s *SshClient) editFile(filePath string, options EditOptions,
+	edit func(content string) (string, error)) (*SshResponse, error) {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	return s.runOnFileBytes(filePath, options, func(content []byte) ([]byte, error) {
+		newContent, err := edit(string(content))
+		return []byte(newContent), err
+	})
+}
+
+func ensureLine(content string, line string) string {
+	lines := splitLines(content)
+	for _, existing := range lines {
+		if strings.TrimSuffix(existing, "\n") == line {
+			return content
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + line + "\n"
+}
+
+func replaceRegexp(content string, expression *regexp.Regexp, replacement string) string {
+	lines := splitLines(content)
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		if expression.MatchString(text) {
+			lines[i] = expression.ReplaceAllString(text, replacement) + line[len(text):]
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func deleteLines(content string, expression *regexp.Regexp) string {
+	var kept []string
+	for _, line := range splitLines(content) {
+		if !expression.MatchString(strings.TrimSuffix(line, "\n")) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+func managedBlock(content string, beginMarker string, endMarker string, block string) string {
+	lines := splitLines(content)
+	begin, end := -1, -1
+	for i, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		if begin < 0 && text == beginMarker {
+			begin = i
+		} else if begin >= 0 && text == endMarker {
+			end = i
+			break
+		}
+	}
+	var managed []string
+	if block != "" {
+		managed = append(managed, beginMarker+"\n")
+		for _, line := range splitLines(block) {
+			managed = append(managed, strings.TrimSuffix(line, "\n")+"\n")
+		}
+		managed = append(managed, endMarker+"\n")
+	}
+	if begin < 0 || end < 0 {
+		if block == "" {
+			return content
+		}
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content + strings.Join(managed, "")
+	}
+	edited := append([]string(nil), lines[:begin]...)
+	edited = append(edited, managed...)
+	edited = append(edited, lines[end+1:]...)
+	return strings.Join(edited, "")
+}
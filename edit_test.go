@@ -0,0 +1,34 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestEnsureLine(t *testing.T) {
+	assert.Equal(t, "a\nb\n", ensureLine("a\nb\n", "b"), "an existing line should be kept")
+	assert.Equal(t, "a\nb\n", ensureLine("a", "b"), "a missing line should be appended")
+	assert.Equal(t, "b\n", ensureLine("", "b"), "a created file should only have the line")
+}
+
+func TestReplaceRegexp(t *testing.T) {
+	expression := regexp.MustCompile(`^#?Port \d+$`)
+	assert.Equal(t, "Port 2222\nUsePAM yes\n", replaceRegexp("#Port 22\nUsePAM yes\n", expression, "Port 2222"))
+}
+
+func TestDeleteLines(t *testing.T) {
+	assert.Equal(t, "a\nc\n", deleteLines("a\nb1\nc\nb2", regexp.MustCompile(`^b`)))
+}
+
+func TestManagedBlock(t *testing.T) {
+	content := managedBlock("a\n", "# BEGIN gosher", "# END gosher", "x\ny")
+	assert.Equal(t, "a\n# BEGIN gosher\nx\ny\n# END gosher\n", content, "a missing block should be appended")
+	assert.Equal(t, content, managedBlock(content, "# BEGIN gosher", "# END gosher", "x\ny\n"), "should be idempotent")
+	assert.Equal(t, "a\n# BEGIN gosher\nz\n# END gosher\n", managedBlock(content, "# BEGIN gosher", "# END gosher", "z"))
+	assert.Equal(t, "a\n", managedBlock(content, "# BEGIN gosher", "# END gosher", ""), "an empty block should be removed")
+	assert.Equal(t, "# BEGIN gosher\nx\n# END gosher\n", managedBlock("", "# BEGIN gosher", "# END gosher", "x"),
+		"a created file should only have the block")
+	assert.Equal(t, "", managedBlock("", "# BEGIN gosher", "# END gosher", ""),
+		"a missing file shouldn't be created for an empty block")
+}
@@ -0,0 +1,89 @@
+package gosher
+
+import (
+	"strings"
+	"sync"
+)
+
+// Prints the facts as key=value lines, missing ones are left out.
+const gatherFactsCommand = `echo hostname=$(uname -n); echo os=$(uname -s); ` +
+	`echo kernel=$(uname -r); echo architecture=$(uname -m); ` +
+	`echo cpus=$(getconf _NPROCESSORS_ONLN 2>/dev/null); ` +
+	`if [ -r /etc/os-release ]; then . /etc/os-release; echo distribution=$ID; echo distribution_version=$VERSION_ID; fi; ` +
+	`if [ -r /proc/meminfo ]; then echo memory_mb=$(($(sed -n 's/^MemTotal: *\([0-9]*\).*/\1/p' /proc/meminfo) / 1024)); fi`
+
+// The facts of a remote machine, shared by a client and its copies.
+type factsCache struct {
+	mutex sync.Mutex
+	facts map[string]string
+}
+
+// Gathers facts about the remote machine: hostname, os, kernel, architecture, cpus,
+// distribution, distribution_version and memory_mb (the last three on Linux only).
+// The facts are gathered once and cached by the client, see ClearFacts.
+// Returns the facts by name and an error if any has occured.
+func (s *SshClient) Facts() (map[string]string, error) {
+	if facts := s.cachedFacts(); facts != nil {
+		return facts, nil
+	}
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	return s.gatherFacts()
+}
+
+// Drops the cached facts, they are gathered again the next time they are needed,
+// e.g. after the remote machine was upgraded.
+func (s *SshClient) ClearFacts() {
+	if s.facts == nil {
+		return
+	}
+	s.facts.mutex.Lock()
+	defer s.facts.mutex.Unlock()
+	s.facts.facts = nil
+}
+
+// Returns the cached facts, nil if they haven't been gathered.
+func (s *SshClient) cachedFacts() map[string]string {
+	if s.facts == nil {
+		return nil
+	}
+	s.facts.mutex.Lock()
+	defer s.facts.mutex.Unlock()
+	return s.facts.facts
+}
+
+// Gathers the facts over the already opened connection unless they are cached.
+func (s *SshClient) gatherFacts() (map[string]string, error) {
+	if facts := s.cachedFacts(); facts != nil {
+		return facts, nil
+	}
+	output, err := s.output(gatherFactsCommand)
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while gathering facts: " + err.Error())
+	}
+	facts := parseFacts(output)
+	if s.facts == nil {
+		// a client which wasn't created by NewSshClient caches its facts on its own
+		s.facts = &factsCache{}
+	}
+	s.facts.mutex.Lock()
+	defer s.facts.mutex.Unlock()
+	s.facts.facts = facts
+	return facts, nil
+}
+
+func parseFacts(output string) map[string]string {
+	facts := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) == 2 && fields[1] != "" {
+			facts[fields[0]] = fields[1]
+		}
+	}
+	return facts
+}
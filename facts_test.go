@@ -0,0 +1,43 @@
+package gosher
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	facts := parseFacts("hostname=web-1\nos=Linux\ncpus=\ndistribution=debian")
+	assert.Equal(t, "web-1", facts["hostname"])
+	assert.Equal(t, "debian", facts["distribution"])
+	_, ok := facts["cpus"]
+	assert.False(t, ok, "empty facts should be left out")
+}
+
+func TestFactsCache(t *testing.T) {
+	client, err := NewSshClient("web-1.invalid", "deploy", PasswordAuthentication, "secret")
+	assert.Nil(t, err)
+	client.StickySession = true
+	copied := client.WithContext(context.Background())
+	assert.True(t, copied.StickySession, "the copy should keep StickySession")
+	copied.facts.facts = map[string]string{"os": "Linux"}
+	// the host can't be resolved, the facts have to come from the cache
+	facts, err := client.Facts()
+	assert.Nil(t, err, "the facts gathered by a copy should be cached by the client")
+	assert.Equal(t, "Linux", facts["os"])
+
+	msc := NewMultipleHostsSshClient(&Host{Client: client})
+	msc.FailFast = true
+	results := msc.collect(func(index int, hostClient *SshClient) (*SshResponse, error) {
+		assert.False(t, hostClient == client, "the task should run with a copy bound to the context")
+		facts, err := hostClient.Facts()
+		assert.Equal(t, "Linux", facts["os"], "the facts shouldn't be gathered again on every task")
+		return &SshResponse{}, err
+	})
+	assert.Nil(t, results[0].Err)
+
+	copied.ClearFacts()
+	assert.Nil(t, client.cachedFacts(), "clearing the facts of a copy should clear them for the client")
+	_, err = client.Facts()
+	assert.NotNil(t, err, "the facts should be gathered again after they were cleared")
+}
@@ -4,11 +4,20 @@
 package gosher
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 const (
@@ -20,13 +29,41 @@ const (
 // StickySession - false by default, if true
 // sessions won't be closed automatically and one would have to use
 // CloseSession()
+// ProgressCallback - nil by default, if set it is invoked with the progress of
+// every Upload and Download
+// BandwidthLimit - 0 (unlimited) by default, the maximum transfer rate
+// of uploads and downloads in bytes per second
+// CheckMode - false by default, if true the operations which change remote files
+// (Upload, RunOnFile and its variants, the editors like EnsureLine and UploadTemplate)
+// only report the Diff and whether the files would be Changed without writing anything
+// Bastion - nil by default, if set the connection is tunnelled through this client
+// like with ssh -J, bastions can have bastions of their own
+// RetryPolicy - nil (no retries) by default, if set Run and RunScript are retried by it,
+// see Retry for the other operations
+// Stdout and Stderr - nil by default, if set the output of Run and RunScript is also written
+// to them as it arrives, it is in the SshResponse either way
 type SshClient struct {
 	Port                int
 	StickySession       bool
 	Address             string
+	ProgressCallback    ProgressCallback
+	BandwidthLimit      int64
+	CheckMode           bool
+	Bastion             *SshClient
+	RetryPolicy         *RetryPolicy
+	Stdout              io.Writer
+	Stderr              io.Writer
 	clientConfiguration ssh.ClientConfig
+	connection          *ssh.Client
 	session             ssh.Session
 	isSessionOpened     bool
+	facts               *factsCache
+	context             context.Context
+	stopWatching        func() bool
+	// an operation is being retried, the operations in it aren't retried on their own
+	retrying bool
+	// the connection to the Bastion
+	bastion *SshClient
 }
 
 // Initializes the SshClient.
@@ -54,6 +91,7 @@ func newPasswordAuthenticatedClient(address string, user string, password string
 		Port:                22,
 		StickySession:       false,
 		isSessionOpened:     false,
+		facts:               &factsCache{},
 	}
 	return client
 }
@@ -75,6 +113,7 @@ func newKeyAuthenticatedClient(address string, user string, keyPath string) (*Ss
 		Port:                22,
 		StickySession:       false,
 		isSessionOpened:     false,
+		facts:               &factsCache{},
 	}
 	return client, err
 }
@@ -91,30 +130,235 @@ func getKeyFromFile(keyPath string) (ssh.Signer, error) {
 	return key, err
 }
 
+// Returns a copy of the client whose operations are bound to ctx, when ctx is done
+// the operations which are running fail and the new ones aren't started.
+// The copy has its own connection, which is closed after every operation unless StickySession is set,
+// and shares the cached facts of the client.
+func (s *SshClient) WithContext(ctx context.Context) *SshClient {
+	client := s.clone()
+	client.context = ctx
+	return client
+}
+
+// Connects to the remote machine, the connection is closed when the context of the client is done.
+func (s *SshClient) dial() (*ssh.Client, error) {
+	hostAndPort := net.JoinHostPort(s.Address, strconv.Itoa(s.Port))
+	ctx := s.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	transport, err := s.dialTransport(ctx, hostAndPort)
+	if err != nil {
+		return nil, err
+	}
+	conn := &transportConn{Conn: transport}
+	// the handshake is interrupted as well
+	stopWatching := context.AfterFunc(ctx, func() { conn.Close() })
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, hostAndPort, &s.clientConfiguration)
+	if err != nil {
+		stopWatching()
+		conn.Close()
+		s.closeBastion()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &handshakeError{err: err, transportErr: conn.failure()}
+	}
+	s.stopWatching = stopWatching
+	return ssh.NewClient(clientConn, channels, requests), nil
+}
+
+// Opens the network connection to the remote machine, tunnelled through the Bastion if it is set.
+func (s *SshClient) dialTransport(ctx context.Context, hostAndPort string) (net.Conn, error) {
+	if s.Bastion == nil {
+		dialer := net.Dialer{Timeout: s.clientConfiguration.Timeout}
+		return dialer.DialContext(ctx, "tcp", hostAndPort)
+	}
+	bastion := s.Bastion.clone()
+	bastion.context = s.context
+	bastionConnection, err := bastion.dial()
+	if err != nil {
+		return nil, fmt.Errorf("bastion %s: %w", bastion.Address, err)
+	}
+	bastion.connection = bastionConnection
+	s.bastion = bastion
+	conn, err := bastionConnection.Dial("tcp", hostAndPort)
+	if err != nil {
+		s.closeBastion()
+		return nil, fmt.Errorf("bastion %s: %w", bastion.Address, err)
+	}
+	return conn, nil
+}
+
+// transportConn - the network connection of a client, which records its first error,
+// so a handshake which failed because of the network can be told apart from a rejected one.
+type transportConn struct {
+	net.Conn
+	mutex sync.Mutex
+	err   error
+}
+
+func (tc *transportConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	tc.record(err)
+	return n, err
+}
+
+func (tc *transportConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	tc.record(err)
+	return n, err
+}
+
+// Records the error unless it is caused by closing the connection.
+func (tc *transportConn) record(err error) {
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	if tc.err == nil {
+		tc.err = err
+	}
+}
+
+func (tc *transportConn) failure() error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	return tc.err
+}
+
+// handshakeError - a failed handshake, transportErr is the error of the network connection if it broke.
+type handshakeError struct {
+	err          error
+	transportErr error
+}
+
+func (he *handshakeError) Error() string {
+	return he.err.Error()
+}
+
+func (he *handshakeError) Unwrap() error {
+	return he.transportErr
+}
+
+// Closes the connection to the bastion, after the connection tunnelled through it.
+func (s *SshClient) closeBastion() {
+	if s.bastion == nil {
+		return
+	}
+	if s.bastion.stopWatching != nil {
+		s.bastion.stopWatching()
+	}
+	s.bastion.connection.Close()
+	s.bastion.closeBastion()
+	s.bastion = nil
+}
+
 func (s *SshClient) newSession() error {
 	if !s.isSessionOpened {
-		hostAndPort := fmt.Sprintf("%s:%d", s.Address, s.Port)
-		client, clientErr := ssh.Dial("tcp", hostAndPort, &s.clientConfiguration)
+		client, clientErr := s.dial()
 		if clientErr != nil {
-			errorMessage := "There was an error while creating a client: " +
-				clientErr.Error()
-			return NewSshConnectionError(errorMessage)
+			return wrapSshConnectionError("There was an error while creating a client: ", &setupError{clientErr})
 		}
 		session, sessionErr := client.NewSession()
 		if sessionErr != nil {
-			errorMessage := "There was an error while establishing a session: " +
-				sessionErr.Error()
-			return NewSshConnectionError(errorMessage)
+			client.Close()
+			s.closeBastion()
+			return wrapSshConnectionError("There was an error while establishing a session: ", &setupError{sessionErr})
 		}
 		s.isSessionOpened = true
+		s.connection = client
 		s.session = *session
 	}
 	return nil
 }
 
+// Returns a copy of the client with its own connection, which is closed after every operation
+// unless StickySession is set. The copy shares the cached facts of the client.
+func (s *SshClient) clone() *SshClient {
+	return &SshClient{
+		Port:                s.Port,
+		StickySession:       s.StickySession,
+		Address:             s.Address,
+		ProgressCallback:    s.ProgressCallback,
+		BandwidthLimit:      s.BandwidthLimit,
+		CheckMode:           s.CheckMode,
+		Bastion:             s.Bastion,
+		RetryPolicy:         s.RetryPolicy,
+		Stdout:              s.Stdout,
+		Stderr:              s.Stderr,
+		clientConfiguration: s.clientConfiguration,
+		facts:               s.facts,
+		context:             s.context,
+	}
+}
+
+// Closes the connection of a copy of the client which was left open by StickySession.
+func (s *SshClient) closeClone() {
+	if s.isSessionOpened {
+		s.CloseSession()
+	}
+}
+
+// Runs a command in a new session over the already opened connection,
+// the client's own session is left untouched.
+// stdin and stdout can be nil.
+func (s *SshClient) runInNewSession(command string, stdin io.Reader, stdout io.Writer) error {
+	session, err := s.connection.NewSession()
+	if err != nil {
+		return NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer session.Close()
+	session.Stdin = stdin
+	session.Stdout = stdout
+	return session.Run(command)
+}
+
+// Runs a command in a new session and returns its trimmed standard output.
+func (s *SshClient) output(command string) (string, error) {
+	var stdout bytes.Buffer
+	err := s.runInNewSession(command, nil, &stdout)
+	return strings.TrimSpace(stdout.String()), err
+}
+
+// Quotes an argument of a remote shell command.
+func shellQuote(argument string) string {
+	return "'" + strings.Replace(argument, "'", `'\''`, -1) + "'"
+}
+
+// Replaces a leading ~ of remotePath with the home directory of the remote user,
+// so the path can be quoted with shellQuote. Other paths are returned as they are.
+func (s *SshClient) expandRemotePath(remotePath string) (string, error) {
+	if !isHomeRelative(remotePath) {
+		return remotePath, nil
+	}
+	// the slash keeps the trailing spaces of the home directory from being trimmed
+	home, err := s.output("printf '%s/' ~")
+	if err != nil {
+		return "", NewSshConnectionError("There was an error while expanding " + remotePath + ": " + err.Error())
+	}
+	return path.Join(home, remotePath[1:]), nil
+}
+
+// Reports whether remotePath starts with ~, the home directory of the remote user.
+func isHomeRelative(remotePath string) bool {
+	return remotePath == "~" || strings.HasPrefix(remotePath, "~/")
+}
+
 // Executes shell command on the remote machine synchronously.
 // Returns an SshResponse and an error if any has occured.
+// The command is retried by the RetryPolicy if it is set.
 func (s *SshClient) Run(command string) (*SshResponse, error) {
+	return s.retry(func() (*SshResponse, error) {
+		return s.run(command)
+	})
+}
+
+func (s *SshClient) run(command string) (*SshResponse, error) {
 	sessionErr := s.newSession()
 	if sessionErr != nil {
 		return nil, sessionErr
@@ -122,20 +366,39 @@ func (s *SshClient) Run(command string) (*SshResponse, error) {
 	if !s.StickySession {
 		defer s.CloseSession()
 	}
-	response := NewSshResponse(s.Address, &s.session)
+	response := s.newCommandResponse()
 	if err := s.session.Run(command); err != nil {
-		errorMessage := "There was an error while executing the command: " +
-			err.Error()
-		return response, NewSshConnectionError(errorMessage)
+		response.setExitCode(err)
+		return response, wrapSshConnectionError("There was an error while executing the command: ", err)
 	}
 	return response, nil
 }
 
+// Returns the response of a command run in the session of the client,
+// its output is written to Stdout and Stderr as well if they are set.
+func (s *SshClient) newCommandResponse() *SshResponse {
+	response := NewSshResponse(s.Address, &s.session)
+	if s.Stdout != nil {
+		s.session.Stdout = io.MultiWriter(&response.StdOut, s.Stdout)
+	}
+	if s.Stderr != nil {
+		s.session.Stderr = io.MultiWriter(&response.StdErr, s.Stderr)
+	}
+	return response
+}
+
 // Executes a shell script file on the remote machine.
 // It is copied in the tmp folder and ran in a single session.
 // chmod +x is applied before running.
 // Returns an SshResponse and an error if any has occured
+// The script is retried by the RetryPolicy if it is set.
 func (s *SshClient) RunScript(scriptPath string) (*SshResponse, error) {
+	return s.retry(func() (*SshResponse, error) {
+		return s.runScript(scriptPath)
+	})
+}
+
+func (s *SshClient) runScript(scriptPath string) (*SshResponse, error) {
 	sessionErr := s.newSession()
 	if sessionErr != nil {
 		return nil, sessionErr
@@ -143,16 +406,15 @@ func (s *SshClient) RunScript(scriptPath string) (*SshResponse, error) {
 	if !s.StickySession {
 		defer s.CloseSession()
 	}
-	response := NewSshResponse(s.Address, &s.session)
+	response := s.newCommandResponse()
 	remotePath := fmt.Sprintf("/tmp/%s", filepath.Base(scriptPath))
-	if _, upErr := s.uploadFile(scriptPath, remotePath); upErr != nil {
+	if _, upErr := s.uploadFile(scriptPath, remotePath, s.newTransferMeter(nil)); upErr != nil {
 		return response, upErr
 	}
 	executeCommand := fmt.Sprintf("chmod +x %s ; %s", remotePath, remotePath)
 	if err := s.session.Run(executeCommand); err != nil {
-		errorMessage := "There was an error while executing the script: " +
-			err.Error()
-		return response, NewSshConnectionError(errorMessage)
+		response.setExitCode(err)
+		return response, wrapSshConnectionError("There was an error while executing the script: ", err)
 	}
 	return response, nil
 }
@@ -163,6 +425,29 @@ func (s *SshClient) RunScript(scriptPath string) (*SshResponse, error) {
 // passed to it and it should return the modified content.
 // Returns SshResponse and an error if any has occured.
 func (s *SshClient) RunOnFile(filePath string, alterContentsFunction func(fileContent string) string) (*SshResponse, error) {
+	return s.RunOnFileBytes(filePath, func(content []byte) ([]byte, error) {
+		return []byte(alterContentsFunction(string(content))), nil
+	})
+}
+
+// Downloads file/folder from the remote machine.
+// Can be used as an alternative to scp.
+// A leading ~ of remotePath is the home directory of the remote user, wildcards aren't expanded,
+// see DownloadMultiple.
+// Returns an SshResponse and an error if any has occured.
+func (s *SshClient) Download(remotePath string, localPath string) (*SshResponse, error) {
+	return s.downloadWithProgress(remotePath, localPath, TransferOptions{}, s.ProgressCallback)
+}
+
+// Downloads file/folder from the remote machine with the given TransferOptions.
+// Returns an SshResponse and an error if any has occured.
+func (s *SshClient) DownloadWithOptions(remotePath string, localPath string,
+	options TransferOptions) (*SshResponse, error) {
+	return s.downloadWithProgress(remotePath, localPath, options, s.ProgressCallback)
+}
+
+func (s *SshClient) downloadWithProgress(remotePath string, localPath string,
+	options TransferOptions, progress ProgressCallback) (*SshResponse, error) {
 	sessionErr := s.newSession()
 	if sessionErr != nil {
 		return nil, sessionErr
@@ -170,44 +455,50 @@ func (s *SshClient) RunOnFile(filePath string, alterContentsFunction func(fileCo
 	if !s.StickySession {
 		defer s.CloseSession()
 	}
-	response := NewSshResponse(s.Address, &s.session)
-	temporaryLocalPath := fmt.Sprintf("/tmp/%s", filepath.Base(filePath))
-	if _, downloadErr := s.download(filePath, temporaryLocalPath); downloadErr != nil {
-		return nil, downloadErr
-	}
-	buf, err := ioutil.ReadFile(temporaryLocalPath)
+	remotePath, err := s.expandRemotePath(remotePath)
 	if err != nil {
 		return nil, err
 	}
-	fileContent := string(buf)
-	newFileContent := alterContentsFunction(fileContent)
-	ioutil.WriteFile(temporaryLocalPath, []byte(newFileContent), os.ModeTemporary)
-	if runErr := s.session.Run("rm -f " + filePath); runErr != nil {
-		return nil, runErr
+	// the path remotePath is going to be downloaded to, needed for the verification
+	destinationPath := localPath
+	if localPathInfo, err := os.Stat(localPath); err == nil && localPathInfo.IsDir() {
+		destinationPath = filepath.Join(localPath, filepath.Base(remotePath))
 	}
-	if _, upErr := s.uploadFile(temporaryLocalPath, filePath); upErr != nil {
-		return nil, upErr
+	meter := s.newTransferMeter(progress)
+	var response *SshResponse
+	archived := false
+	if options.Archive {
+		response, archived, err = s.downloadArchive(remotePath, destinationPath, meter, options)
 	}
-	return response, nil
-}
-
-// Downloads file/folder from the remote machine.
-// Can be used as an alternative to scp.
-// Returns an SshResponse and an error if any has occured.
-func (s *SshClient) Download(remotePath string, localPath string) (*SshResponse, error) {
-	sessionErr := s.newSession()
-	if sessionErr != nil {
-		return nil, sessionErr
+	if archived || err != nil {
+		// already downloaded or failed
+	} else if options.Resume {
+		response, err = s.downloadResumable(remotePath, localPath, meter, options)
+	} else {
+		response, err = s.download(remotePath, localPath, meter, options)
 	}
-	if !s.StickySession {
-		defer s.CloseSession()
+	if err == nil && options.Verify {
+		err = s.verifyDownload(remotePath, destinationPath, options)
 	}
-	return s.download(remotePath, localPath)
+	return response, err
 }
 
 // Uploads file/folder to the remote machine.
+// A leading ~ of remotePath is the home directory of the remote user.
 // Returns an SshResponse and an error if any has occured.
 func (s *SshClient) Upload(localPath string, remotePath string) (*SshResponse, error) {
+	return s.uploadWithProgress(localPath, remotePath, TransferOptions{}, s.ProgressCallback)
+}
+
+// Uploads file/folder to the remote machine with the given TransferOptions.
+// Returns an SshResponse and an error if any has occured.
+func (s *SshClient) UploadWithOptions(localPath string, remotePath string,
+	options TransferOptions) (*SshResponse, error) {
+	return s.uploadWithProgress(localPath, remotePath, options, s.ProgressCallback)
+}
+
+func (s *SshClient) uploadWithProgress(localPath string, remotePath string,
+	options TransferOptions, progress ProgressCallback) (*SshResponse, error) {
 	localPathInfo, err := os.Stat(localPath)
 	if err != nil {
 		return nil, err
@@ -219,16 +510,46 @@ func (s *SshClient) Upload(localPath string, remotePath string) (*SshResponse, e
 	if !s.StickySession {
 		defer s.CloseSession()
 	}
-	if localPathInfo.IsDir() {
-		return s.uploadFolder(localPath, remotePath)
+	// every transfer mode and the verification quote the path, so ~ is expanded before
+	if remotePath, err = s.expandRemotePath(remotePath); err != nil {
+		return nil, err
+	}
+	if s.CheckMode {
+		return s.checkUpload(localPath, remotePath, localPathInfo.IsDir(), options)
+	}
+	meter := s.newTransferMeter(progress)
+	var response *SshResponse
+	archived := false
+	if localPathInfo.IsDir() && options.Archive {
+		response, archived, err = s.uploadArchive(localPath, remotePath, meter, options)
+	}
+	if archived {
+		// already uploaded or failed
+	} else if localPathInfo.IsDir() {
+		response, err = s.uploadFolder(localPath, remotePath, meter, options)
+	} else if options.Resume {
+		response, err = s.uploadResumable(localPath, remotePath, meter)
 	} else {
-		return s.uploadFile(localPath, remotePath)
+		response, err = s.uploadFile(localPath, remotePath, meter)
 	}
+	if err == nil && options.Verify {
+		err = s.verifyUpload(localPath, remotePath, options)
+	}
+	return response, err
 }
 
 // Closes the session, use only with StickySession set to true
 func (s *SshClient) CloseSession() error {
 	s.isSessionOpened = false
 	s.session.Close()
+	if s.stopWatching != nil {
+		s.stopWatching()
+		s.stopWatching = nil
+	}
+	if s.connection != nil {
+		s.connection.Close()
+		s.connection = nil
+	}
+	s.closeBastion()
 	return nil
 }
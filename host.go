@@ -4,10 +4,19 @@ package gosher
 // Use with the multipleHostsSshClient with asynchronous execution.
 // ResultChannel - the channel via which the SshResponse of the operations will be passed.
 // ErrorChannel - the channel via which the error of the operations will be passed.
+// Every operation which passes its results via the channels (like MultipleHostsSshClient.Run)
+// sends one value to either channel of every host and it waits in a goroutine until it is received,
+// so the channels have to be drained. A nil channel drops the values, use the blocking API
+// (like MultipleHostsSshClient.RunAll) if the channels aren't read.
+// Alias - empty by default, a name of the host used instead of its address
+// e.g. when naming downloaded files.
+// Vars - nil by default, variables of the host e.g. from an inventory, available in templates.
 type Host struct {
 	Client        *SshClient
 	ResultChannel chan *SshResponse
 	ErrorChannel  chan error
+	Alias         string
+	Vars          map[string]interface{}
 }
 
 // Constructor method for the Host type
@@ -23,3 +32,11 @@ func NewHost(address string, user string, authenticationType int, authentication
 	host.ErrorChannel = errorChannel
 	return host, nil
 }
+
+// Returns the Alias of the host or its address if it has none.
+func (h *Host) Name() string {
+	if h.Alias != "" {
+		return h.Alias
+	}
+	return h.Client.Address
+}
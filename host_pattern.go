@@ -0,0 +1,316 @@
+package gosher
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A host which a host pattern is matched against.
+// names - the names the host can be selected by, groups - all groups it is in.
+type patternCandidate struct {
+	names  []string
+	groups []string
+	vars   map[string]interface{}
+}
+
+// A term of a host pattern, matched against a candidate.
+type patternTerm struct {
+	operator byte
+	matches  func(candidate patternCandidate) bool
+}
+
+// Expands the ranges in a host name like "db[01:10].example.com" or "web-[a:c]" into the names of the hosts,
+// a range can have a stride like "[1:9:2]". Numbers are padded to the length of the start of the range.
+// A name can have several ranges, names without ranges are returned as they are.
+func ExpandHostRange(name string) ([]string, error) {
+	start := strings.Index(name, "[")
+	if start < 0 {
+		if strings.Contains(name, "]") {
+			return nil, errors.New("Unexpected ] in " + name)
+		}
+		return []string{name}, nil
+	}
+	end := strings.Index(name[start:], "]")
+	if end < 0 {
+		return nil, errors.New("Unterminated range in " + name)
+	}
+	end += start
+	values, err := expandRange(name[start+1 : end])
+	if err != nil {
+		return nil, errors.New("Invalid range in " + name + ": " + err.Error())
+	}
+	suffixes, err := ExpandHostRange(name[end+1:])
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(values)*len(suffixes))
+	for _, value := range values {
+		for _, suffix := range suffixes {
+			names = append(names, name[:start]+value+suffix)
+		}
+	}
+	return names, nil
+}
+
+// Expands a range like "01:10", "a:f" or "1:9:2".
+func expandRange(rangeText string) ([]string, error) {
+	bounds := strings.Split(rangeText, ":")
+	if len(bounds) != 2 && len(bounds) != 3 {
+		return nil, errors.New("expected [start:end] or [start:end:stride]")
+	}
+	stride := 1
+	if len(bounds) == 3 {
+		var err error
+		if stride, err = strconv.Atoi(bounds[2]); err != nil || stride <= 0 {
+			return nil, errors.New("the stride has to be a positive number")
+		}
+	}
+	first, last := bounds[0], bounds[1]
+	var values []string
+	if isLetter(first) && isLetter(last) {
+		if first > last {
+			return nil, errors.New("the start is after the end")
+		}
+		for c := first[0]; c <= last[0]; c += byte(stride) {
+			values = append(values, string(c))
+			if int(c)+stride > 'z' {
+				break
+			}
+		}
+		return values, nil
+	}
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, errors.New("the start has to be a number or a letter")
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil {
+		return nil, errors.New("the end has to be a number or a letter")
+	}
+	if from > to {
+		return nil, errors.New("the start is after the end")
+	}
+	for i := from; i <= to; i += stride {
+		values = append(values, fmt.Sprintf("%0*d", len(first), i))
+	}
+	return values, nil
+}
+
+func isLetter(value string) bool {
+	return len(value) == 1 && (value[0] >= 'a' && value[0] <= 'z' || value[0] >= 'A' && value[0] <= 'Z')
+}
+
+// Parses a host pattern, see Inventory.Select.
+func parseHostPattern(pattern string) ([]patternTerm, error) {
+	var terms []patternTerm
+	for _, text := range splitHostPattern(pattern) {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		term := patternTerm{operator: '|'}
+		if text[0] == '&' || text[0] == '!' {
+			term.operator = text[0]
+			text = text[1:]
+		}
+		matches, err := parsePatternTerm(text)
+		if err != nil {
+			return nil, err
+		}
+		term.matches = matches
+		terms = append(terms, term)
+	}
+	if len(terms) == 0 {
+		return nil, errors.New("The host pattern " + pattern + " is empty")
+	}
+	return terms, nil
+}
+
+// Splits a pattern on commas, or on colons outside of ranges if it has no commas.
+func splitHostPattern(pattern string) []string {
+	if strings.Contains(pattern, ",") {
+		return strings.Split(pattern, ",")
+	}
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, pattern[start:])
+}
+
+func parsePatternTerm(text string) (func(candidate patternCandidate) bool, error) {
+	if text == "" {
+		return nil, errors.New("Empty term in the host pattern")
+	}
+	if text == "all" || text == "*" {
+		return func(candidate patternCandidate) bool {
+			return true
+		}, nil
+	}
+	if text[0] == '~' {
+		expression, err := regexp.Compile(text[1:])
+		if err != nil {
+			return nil, errors.New("Invalid regular expression in the host pattern: " + err.Error())
+		}
+		return func(candidate patternCandidate) bool {
+			for _, name := range candidate.names {
+				if expression.MatchString(name) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	if equals := strings.Index(text, "="); equals > 0 {
+		key, value := text[:equals], text[equals+1:]
+		return func(candidate patternCandidate) bool {
+			return varMatches(candidate.vars[key], value)
+		}, nil
+	}
+	names, err := ExpandHostRange(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, errors.New("Invalid wildcard in the host pattern: " + name)
+		}
+	}
+	return func(candidate patternCandidate) bool {
+		for _, name := range names {
+			if matchesAny(name, candidate.names) || matchesAny(name, candidate.groups) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// Reports whether a variable is value, or contains value if it is a list.
+func varMatches(variable interface{}, value string) bool {
+	if list, isList := variable.([]interface{}); isList {
+		for _, item := range list {
+			if fmt.Sprint(item) == value {
+				return true
+			}
+		}
+		return false
+	}
+	if list, isList := variable.([]string); isList {
+		return containsString(list, value)
+	}
+	return variable != nil && fmt.Sprint(variable) == value
+}
+
+func matchesAny(pattern string, values []string) bool {
+	for _, value := range values {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the indexes of the candidates selected by the terms: the union of the plain terms,
+// intersected with every & term, without the hosts of the ! terms, regardless of their order.
+func selectCandidates(terms []patternTerm, candidates []patternCandidate) []int {
+	var selected []int
+	for i, candidate := range candidates {
+		included := false
+		for _, term := range terms {
+			if term.operator == '|' && term.matches(candidate) {
+				included = true
+				break
+			}
+		}
+		for _, term := range terms {
+			if !included {
+				break
+			}
+			if term.operator == '&' && !term.matches(candidate) || term.operator == '!' && term.matches(candidate) {
+				included = false
+			}
+		}
+		if included {
+			selected = append(selected, i)
+		}
+	}
+	return selected
+}
+
+// Returns the hosts of the inventory selected by a host pattern, in the order of the inventory.
+// A pattern is a list of terms separated by colons or commas, use commas if a term has a colon:
+// all or * - all hosts,
+// a group, a host or a name with wildcards like "web-*", which matches both,
+// a host name with ranges like "db[01:10].example.com", see ExpandHostRange,
+// ~ and a regular expression matched against the names of the hosts like "~web-\d+",
+// key=value - the hosts whose variable is value, or contains it if it is a list, like "tags=canary".
+// The hosts of the terms are added together, a term starting with & keeps only the hosts it matches
+// and a term starting with ! removes the hosts it matches, like "web:&prod:!web-3".
+// No hosts are selected if nothing matches.
+func (inv *Inventory) Select(pattern string) ([]*InventoryHost, error) {
+	terms, err := parseHostPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]patternCandidate, len(inv.Hosts))
+	for i, host := range inv.Hosts {
+		candidates[i] = patternCandidate{
+			names:  []string{host.Name},
+			groups: inv.hostGroups(host.Name),
+			vars:   inv.HostVars(host.Name),
+		}
+	}
+	var hosts []*InventoryHost
+	for _, index := range selectCandidates(terms, candidates) {
+		hosts = append(hosts, inv.Hosts[index])
+	}
+	return hosts, nil
+}
+
+// Returns a copy of the client with the hosts selected by a host pattern, see Inventory.Select.
+// Hosts are matched by their Alias and their address, groups by the group_names variable
+// which the hosts of an inventory have, the other variables are the Vars of the hosts.
+func (msc *MultipleHostsSshClient) Select(pattern string) (*MultipleHostsSshClient, error) {
+	terms, err := parseHostPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]patternCandidate, len(msc.Hosts))
+	for i, host := range msc.Hosts {
+		candidate := patternCandidate{names: []string{host.Client.Address}, vars: host.Vars}
+		if host.Alias != "" {
+			candidate.names = append(candidate.names, host.Alias)
+		}
+		switch groups := host.Vars["group_names"].(type) {
+		case []string:
+			candidate.groups = groups
+		case []interface{}:
+			for _, group := range groups {
+				candidate.groups = append(candidate.groups, fmt.Sprint(group))
+			}
+		}
+		candidates[i] = candidate
+	}
+	client := *msc
+	client.Hosts = nil
+	for _, index := range selectCandidates(terms, candidates) {
+		client.Hosts = append(client.Hosts, msc.Hosts[index])
+	}
+	return &client, nil
+}
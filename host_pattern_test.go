@@ -0,0 +1,94 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExpandHostRange(t *testing.T) {
+	expansions := map[string][]string{
+		"web":                   {"web"},
+		"db[01:03].example.com": {"db01.example.com", "db02.example.com", "db03.example.com"},
+		"web-[a:c]":             {"web-a", "web-b", "web-c"},
+		"node[1:9:4]":           {"node1", "node5", "node9"},
+		"r[1:2]-[x:y]":          {"r1-x", "r1-y", "r2-x", "r2-y"},
+	}
+	for name, expected := range expansions {
+		names, err := ExpandHostRange(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, names, name)
+	}
+	for _, name := range []string{"web[1:", "web]", "web[3:1]", "web[1:a]", "web[1:3:0]", "web[1]"} {
+		_, err := ExpandHostRange(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func selectNames(t *testing.T, inventory *Inventory, pattern string) []string {
+	hosts, err := inventory.Select(pattern)
+	assert.Nil(t, err, pattern)
+	names := []string{}
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return names
+}
+
+func TestSelect(t *testing.T) {
+	inventory, err := ParseInventory([]byte(`
+[web]
+web-[1:3] tags=canary
+web-4
+
+[db]
+db[01:02].example.com:2222
+
+[prod:children]
+web
+db
+
+[staging]
+web-4
+`), InventoryINI)
+	assert.Nil(t, err)
+	assert.Equal(t, 2222, inventory.Host("db01.example.com").Vars["ansible_port"])
+
+	patterns := map[string][]string{
+		"all":                    {"web-1", "web-2", "web-3", "web-4", "db01.example.com", "db02.example.com"},
+		"web:&prod:!web-3":       {"web-1", "web-2", "web-4"},
+		"!web-3:web":             {"web-1", "web-2", "web-4"},
+		"prod:&staging":          {"web-4"},
+		"db[01:01].example.com":  {"db01.example.com"},
+		"web-*:!staging":         {"web-1", "web-2", "web-3"},
+		`~^db\d+`:                {"db01.example.com", "db02.example.com"},
+		"tags=canary:!web-1":     {"web-2", "web-3"},
+		"missing":                {},
+		"web-4,db02.example.com": {"web-4", "db02.example.com"},
+	}
+	for pattern, expected := range patterns {
+		assert.Equal(t, expected, selectNames(t, inventory, pattern), pattern)
+	}
+	for _, pattern := range []string{"", "~(", "web[1:"} {
+		_, err := inventory.Select(pattern)
+		assert.NotNil(t, err, pattern)
+	}
+}
+
+func TestMultipleHostsSelect(t *testing.T) {
+	msc := newTestHosts("10.0.0.1", "10.0.0.2", "10.0.0.3")
+	msc.Hosts[0].Alias = "web-1"
+	msc.Hosts[0].Vars = map[string]interface{}{"group_names": []string{"web"}}
+	msc.Hosts[1].Vars = map[string]interface{}{"group_names": []string{"web"}, "role": "lb"}
+	msc.Parallelism = 2
+
+	selected, err := msc.Select("web:!role=lb")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(selected.Hosts))
+	assert.Equal(t, msc.Hosts[0], selected.Hosts[0])
+	assert.Equal(t, 2, selected.Parallelism, "the settings of the client should be kept")
+	assert.Equal(t, 3, len(msc.Hosts), "the client itself shouldn't change")
+
+	selected, err = msc.Select("10.0.0.[2:3]")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(selected.Hosts))
+}
@@ -0,0 +1,227 @@
+package gosher
+
+import (
+	"context"
+	"time"
+)
+
+// HostResult - the outcome of an operation on a single host.
+// Index - the index of the host in the Hosts of the MultipleHostsSshClient.
+// Response - the SshResponse of the operation, it can be set even if the operation failed.
+// Err - nil if the operation succeeded, a HostSkippedError if it wasn't started on the host
+// or was cancelled on it because the Quorum was reached.
+// Duration - how long the operation took on the host, including the retries.
+// Attempts - the number of times the operation was attempted on the host, see RetryPolicy.
+type HostResult struct {
+	Index    int
+	Host     *Host
+	Address  string
+	Response *SshResponse
+	Err      error
+	Duration time.Duration
+	Attempts int
+}
+
+// Reports whether the operation wasn't started on the host or was cancelled because the Quorum was reached.
+func (result HostResult) Skipped() bool {
+	_, isSkipped := result.Err.(*HostSkippedError)
+	return isSkipped
+}
+
+// Splits the results into the hosts where the operation succeeded, failed (including the hosts
+// where it was cancelled while running, unless the Quorum was reached) and where it was skipped.
+func SplitResults(results []HostResult) (succeeded []HostResult, failed []HostResult, skipped []HostResult) {
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			succeeded = append(succeeded, result)
+		case result.Skipped():
+			skipped = append(skipped, result)
+		default:
+			failed = append(failed, result)
+		}
+	}
+	return succeeded, failed, skipped
+}
+
+// Operation - an operation executed on every host by Execute and Stream.
+// client is the client of the host, in CheckMode a copy of it which doesn't write anything.
+type Operation func(host *Host, client *SshClient) (*SshResponse, error)
+
+// The operation executed on the host with the given index with the client it has to use.
+type hostTask func(index int, client *SshClient) (*SshResponse, error)
+
+// ResultStream - the results of an operation on all hosts in the order the hosts finish.
+// Use it like this:
+//
+//	stream := client.Stream(operation)
+//	for stream.Next() {
+//		result := stream.Result()
+//	}
+type ResultStream struct {
+	results <-chan HostResult
+	current HostResult
+}
+
+// Waits for the next host to finish, returns false when all hosts have finished.
+func (rs *ResultStream) Next() bool {
+	result, ok := <-rs.results
+	rs.current = result
+	return ok
+}
+
+// Returns the result of the host which finished last.
+func (rs *ResultStream) Result() HostResult {
+	return rs.current
+}
+
+// Executes operation on all hosts in a separate goroutine for each and blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) Execute(operation Operation) []HostResult {
+	return msc.collect(msc.operationTask(operation))
+}
+
+// Executes operation on all hosts in a separate goroutine for each and returns a stream
+// of their results in the order they finish, it doesn't wait for the hosts.
+func (msc *MultipleHostsSshClient) Stream(operation Operation) *ResultStream {
+	return &ResultStream{results: msc.execute(msc.operationTask(operation))}
+}
+
+// Executes shell command on all hosts and blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) RunAll(command string) []HostResult {
+	return msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.Run(command)
+	})
+}
+
+// Executes shell script on all hosts and blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) RunScriptAll(filePath string) []HostResult {
+	return msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.RunScript(filePath)
+	})
+}
+
+// Uploads a file/folder to all hosts with the given TransferOptions and blocks until all of them finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) UploadAll(localPath string, remotePath string, options TransferOptions) []HostResult {
+	return msc.collect(msc.uploadTask(localPath, remotePath, options))
+}
+
+// Downloads files/folders from all hosts with the given TransferOptions and blocks until all of them finish,
+// they are named by the DownloadNaming template.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) DownloadAll(remotePath string, localPath string,
+	options TransferOptions) []HostResult {
+	return msc.collect(msc.downloadTask(remotePath, localPath, options))
+}
+
+func (msc *MultipleHostsSshClient) operationTask(operation Operation) hostTask {
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		return operation(msc.Hosts[index], client)
+	}
+}
+
+// Executes task on all hosts and returns their results in the order of the hosts.
+func (msc *MultipleHostsSshClient) collect(task hostTask) []HostResult {
+	results := make([]HostResult, len(msc.Hosts))
+	for result := range msc.execute(task) {
+		results[result.Index] = result
+	}
+	return results
+}
+
+// Executes task on all hosts in a separate goroutine for each, limited by Parallelism and
+// rolled out in the batches of Serial after the Canary hosts, and sends the result of every host
+// as soon as it finishes. The hosts after a failed canary or a batch with too many failures,
+// or after the first failure with FailFast or once the Quorum is decided, are skipped.
+// The channel is closed when all hosts have finished.
+func (msc *MultipleHostsSshClient) execute(task hostTask) <-chan HostResult {
+	results := make(chan HostResult, len(msc.Hosts))
+	if msc.LiveOutput != nil {
+		task = msc.LiveOutput.hostTask(msc, task)
+	}
+	go func() {
+		defer close(results)
+		ctx, cancel := msc.executionContext()
+		defer cancel(nil)
+		batches, err := msc.batches()
+		if err != nil {
+			for i := range msc.Hosts {
+				results <- msc.skippedResult(i, err)
+			}
+			return
+		}
+		onResult := msc.resultHandler(cancel)
+		for b, batch := range batches {
+			batchResults := msc.runBatch(batch, task, ctx, onResult, results)
+			skipErr := msc.abortReason(b, batchResults)
+			if skipErr == nil {
+				continue
+			}
+			for _, remaining := range batches[b+1:] {
+				for _, index := range remaining {
+					results <- msc.skippedResult(index, skipErr)
+				}
+			}
+			return
+		}
+	}()
+	return results
+}
+
+// Executes task on the host with the given index, retried by the RetryPolicy, and measures how long it takes.
+// The client of the host is bound to ctx if it isn't nil.
+func (msc *MultipleHostsSshClient) runTask(index int, task hostTask, ctx context.Context) HostResult {
+	host := msc.Hosts[index]
+	client := msc.client(index)
+	if ctx != nil {
+		client = client.WithContext(ctx)
+	}
+	if msc.RetryPolicy != nil && client.RetryPolicy != nil {
+		if client == host.Client {
+			client = client.clone()
+		}
+		client.RetryPolicy = nil
+	}
+	if client != host.Client {
+		defer client.closeClone()
+	}
+	start := time.Now()
+	response, attempts, err := msc.RetryPolicy.run(ctx, func() (*SshResponse, error) {
+		return task(index, client)
+	}, client.resetSession)
+	if err != nil && ctx != nil && ctx.Err() != nil {
+		if skipErr, ok := context.Cause(ctx).(*HostSkippedError); ok {
+			err = skipErr
+		} else {
+			err = NewSshConnectionError("The operation was cancelled (" + context.Cause(ctx).Error() + "): " + err.Error())
+		}
+	}
+	return HostResult{
+		Index:    index,
+		Host:     host,
+		Address:  host.Client.Address,
+		Response: response,
+		Err:      err,
+		Duration: time.Since(start),
+		Attempts: attempts,
+	}
+}
+
+// Passes the result to the channels of its host without blocking the other hosts.
+// A goroutine waits until the result is received, it is dropped if the channel is nil.
+func (msc *MultipleHostsSshClient) deliver(result HostResult) {
+	host := msc.Hosts[result.Index]
+	if result.Err != nil && host.ErrorChannel == nil || result.Err == nil && host.ResultChannel == nil {
+		return
+	}
+	go func() {
+		if result.Err != nil {
+			host.ErrorChannel <- result.Err
+			return
+		}
+		host.ResultChannel <- result.Response
+	}()
+}
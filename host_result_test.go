@@ -0,0 +1,74 @@
+package gosher
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestHosts(addresses ...string) *MultipleHostsSshClient {
+	var hosts []*Host
+	for _, address := range addresses {
+		hosts = append(hosts, &Host{
+			Client:        &SshClient{Address: address},
+			ResultChannel: make(chan *SshResponse),
+			ErrorChannel:  make(chan error),
+		})
+	}
+	return NewMultipleHostsSshClient(hosts...)
+}
+
+// Fails on the second host, the first one finishes last.
+func testTask(index int, client *SshClient) (*SshResponse, error) {
+	if index == 1 {
+		return nil, errors.New("connection refused")
+	}
+	if index == 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	return &SshResponse{}, nil
+}
+
+func TestCollect(t *testing.T) {
+	msc := newTestHosts("web-1", "web-2", "web-3")
+	results := msc.collect(testTask)
+	assert.Equal(t, 3, len(results))
+	for i, result := range results {
+		assert.Equal(t, i, result.Index, "the results should be in the order of the hosts")
+		assert.Equal(t, msc.Hosts[i], result.Host)
+	}
+	assert.Equal(t, "web-2", results[1].Address)
+	assert.NotNil(t, results[1].Err)
+	assert.True(t, results[0].Duration >= 20*time.Millisecond)
+}
+
+func TestResultStream(t *testing.T) {
+	msc := newTestHosts("web-1", "web-2", "web-3")
+	stream := &ResultStream{results: msc.execute(testTask)}
+	var order []int
+	for stream.Next() {
+		order = append(order, stream.Result().Index)
+	}
+	assert.Equal(t, 3, len(order))
+	assert.Equal(t, 0, order[2], "the results should come as the hosts finish")
+}
+
+func TestDeliverToNilChannels(t *testing.T) {
+	msc := newTestHosts("web-1", "web-2")
+	msc.Hosts[0].ResultChannel = nil
+	msc.Hosts[1].ErrorChannel = nil
+	before := runtime.NumGoroutine()
+	msc.deliver(HostResult{Index: 0, Response: &SshResponse{}})
+	msc.deliver(HostResult{Index: 1, Err: errors.New("connection refused")})
+	assert.True(t, runtime.NumGoroutine() <= before, "nothing should wait to send to a nil channel")
+}
+
+func TestForEachHostDelivers(t *testing.T) {
+	msc := newTestHosts("web-1", "web-2")
+	msc.forEachHost(testTask)
+	// the channels are unbuffered and read in the opposite order of the results
+	assert.NotNil(t, <-msc.Hosts[1].ErrorChannel)
+	assert.NotNil(t, <-msc.Hosts[0].ResultChannel)
+}
@@ -0,0 +1,18 @@
+package gosher
+
+// Error of the hosts on which an operation of the MultipleHostsSshClient wasn't started,
+// e.g. because the rollout was aborted, or was cancelled because the Quorum was reached.
+type HostSkippedError struct {
+	Reason string
+}
+
+// Returns the error message of the HostSkippedError
+func (he *HostSkippedError) Error() string {
+	return "The host was skipped: " + he.Reason
+}
+
+func NewHostSkippedError(reason string) *HostSkippedError {
+	return &HostSkippedError{
+		Reason: reason,
+	}
+}
@@ -0,0 +1,484 @@
+package gosher
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats of inventory files.
+const (
+	InventoryINI = iota
+	InventoryYAML
+	InventoryJSON
+)
+
+// The variables which configure the connection to a host,
+// the Ansible names and the short names are both accepted.
+var (
+	addressVars  = []string{"ansible_host", "address"}
+	userVars     = []string{"ansible_user", "user"}
+	portVars     = []string{"ansible_port", "port"}
+	keyVars      = []string{"ansible_ssh_private_key_file", "key"}
+	passwordVars = []string{"ansible_password", "password"}
+	bastionVars  = []string{"bastion"}
+)
+
+// Inventory - hosts and groups of hosts with their variables, usually loaded with LoadInventory.
+// Hosts and Groups are in the order they are defined in. Every host is in the group "all"
+// and the hosts which aren't in any other group are in the group "ungrouped" too.
+type Inventory struct {
+	Hosts  []*InventoryHost
+	Groups []*InventoryGroup
+	hosts  map[string]*InventoryHost
+	groups map[string]*InventoryGroup
+}
+
+// InventoryHost - a host of an Inventory.
+// Vars - the variables of the host itself, see Inventory.HostVars for all of its variables.
+// Groups - the groups the host is directly in.
+type InventoryHost struct {
+	Name   string
+	Vars   map[string]interface{}
+	Groups []string
+}
+
+// InventoryGroup - a group of an Inventory.
+// Hosts and Children - the hosts and the groups directly in the group.
+// Vars - the variables of all hosts in the group and in its children.
+type InventoryGroup struct {
+	Name     string
+	Hosts    []string
+	Children []string
+	Vars     map[string]interface{}
+}
+
+// Loads an inventory file, the format is chosen by the extension: .yaml and .yml are YAML,
+// .json is JSON and anything else is an Ansible INI inventory.
+func LoadInventory(path string) (*Inventory, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := InventoryINI
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = InventoryYAML
+	case ".json":
+		format = InventoryJSON
+	}
+	inventory, err := ParseInventory(content, format)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return inventory, nil
+}
+
+// Parses an inventory in the given format.
+// INI inventories are in the Ansible format: host lines with key=value variables,
+// [group], [group:vars] and [group:children] sections.
+// YAML and JSON inventories are in the Ansible YAML format: groups with hosts, vars and children,
+// JSON inventories can be in the format of Ansible inventory scripts with _meta.hostvars as well.
+func ParseInventory(content []byte, format int) (*Inventory, error) {
+	inventory := newInventory()
+	var err error
+	switch format {
+	case InventoryINI:
+		err = inventory.parseINI(string(content))
+	case InventoryYAML, InventoryJSON:
+		err = inventory.parseStructured(content)
+	default:
+		err = errors.New("Unknown inventory format " + strconv.Itoa(format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inventory, inventory.finish()
+}
+
+func newInventory() *Inventory {
+	return &Inventory{
+		hosts:  make(map[string]*InventoryHost),
+		groups: make(map[string]*InventoryGroup),
+	}
+}
+
+// Returns the host with the given name, nil if there is none.
+func (inv *Inventory) Host(name string) *InventoryHost {
+	return inv.hosts[name]
+}
+
+// Returns the group with the given name, nil if there is none.
+func (inv *Inventory) Group(name string) *InventoryGroup {
+	return inv.groups[name]
+}
+
+// Returns the hosts in the group and in all of its children in the order of the inventory.
+func (inv *Inventory) GroupHosts(name string) ([]*InventoryHost, error) {
+	if inv.groups[name] == nil {
+		return nil, errors.New("There is no group " + name + " in the inventory")
+	}
+	members := make(map[string]bool)
+	inv.addGroupMembers(name, members)
+	var hosts []*InventoryHost
+	for _, host := range inv.Hosts {
+		if members[host.Name] {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+func (inv *Inventory) addGroupMembers(name string, members map[string]bool) {
+	group := inv.groups[name]
+	for _, host := range group.Hosts {
+		members[host] = true
+	}
+	for _, child := range group.Children {
+		inv.addGroupMembers(child, members)
+	}
+}
+
+// Returns all variables of a host: the variables of its groups, those of the parent groups
+// overridden by those of their children and groups of the same depth in alphabetical order,
+// overridden by the variables of the host itself, like in Ansible.
+func (inv *Inventory) HostVars(name string) map[string]interface{} {
+	vars := make(map[string]interface{})
+	host := inv.hosts[name]
+	if host == nil {
+		return vars
+	}
+	depths := inv.groupDepths(host)
+	groups := make([]string, 0, len(depths))
+	for group := range depths {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if depths[groups[i]] != depths[groups[j]] {
+			return depths[groups[i]] < depths[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+	for _, group := range groups {
+		for key, value := range inv.groups[group].Vars {
+			vars[key] = value
+		}
+	}
+	for key, value := range host.Vars {
+		vars[key] = value
+	}
+	return vars
+}
+
+// Returns all groups the host is in, directly or through their children, with their depth below "all".
+func (inv *Inventory) groupDepths(host *InventoryHost) map[string]int {
+	depths := make(map[string]int)
+	for _, group := range host.Groups {
+		inv.addAncestors(group, depths)
+	}
+	return depths
+}
+
+// Returns all groups the host is in, directly or through their children, sorted by name.
+func (inv *Inventory) hostGroups(name string) []string {
+	depths := inv.groupDepths(inv.hosts[name])
+	groups := make([]string, 0, len(depths))
+	for group := range depths {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Adds the group and all groups it is in with their depth below "all".
+func (inv *Inventory) addAncestors(name string, depths map[string]int) int {
+	if depth, ok := depths[name]; ok {
+		return depth
+	}
+	depth := 0
+	for _, parent := range inv.parents(name) {
+		if parentDepth := inv.addAncestors(parent, depths) + 1; parentDepth > depth {
+			depth = parentDepth
+		}
+	}
+	depths[name] = depth
+	return depth
+}
+
+func (inv *Inventory) parents(name string) []string {
+	var parents []string
+	for _, group := range inv.Groups {
+		for _, child := range group.Children {
+			if child == name {
+				parents = append(parents, group.Name)
+			}
+		}
+	}
+	return parents
+}
+
+// Returns a Host for the inventory host with the given name, its connection is configured by its variables:
+// ansible_host or address - the name of the host by default,
+// ansible_user or user - the current user by default,
+// ansible_port or port - 22 by default,
+// ansible_ssh_private_key_file or key, or ansible_password or password - one of them is required,
+// bastion - the name of another host of the inventory or an address like "user@host:port",
+// which is connected to with the user and the key or password of the host.
+// The Alias of the Host is its name and its Vars are all of its variables with inventory_hostname,
+// its name, and group_names, the groups it is in except "all" and "ungrouped", like in Ansible.
+func (inv *Inventory) NewHost(name string, resultChannel chan *SshResponse, errorChannel chan error) (*Host, error) {
+	if inv.hosts[name] == nil {
+		return nil, errors.New("There is no host " + name + " in the inventory")
+	}
+	vars := inv.HostVars(name)
+	client, err := inv.newClient(name, vars, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	var groupNames []string
+	for _, group := range inv.hostGroups(name) {
+		if group != "all" && group != "ungrouped" {
+			groupNames = append(groupNames, group)
+		}
+	}
+	vars["inventory_hostname"] = name
+	vars["group_names"] = groupNames
+	return &Host{
+		Client:        client,
+		ResultChannel: resultChannel,
+		ErrorChannel:  errorChannel,
+		Alias:         name,
+		Vars:          vars,
+	}, nil
+}
+
+// Returns a MultipleHostsSshClient for the hosts selected by a host pattern, see Select and NewHost.
+// The pattern can be just the name of a group.
+// The client is created for all of the hosts or for none of them, if the variables of a host are invalid
+// (e.g. it has neither a key nor a password) an error naming the host is returned.
+func (inv *Inventory) NewMultipleHostsSshClient(pattern string, resultChannel chan *SshResponse,
+	errorChannel chan error) (*MultipleHostsSshClient, error) {
+	inventoryHosts, err := inv.Select(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(inventoryHosts) == 0 {
+		return nil, errors.New("No hosts of the inventory match " + pattern)
+	}
+	hosts := make([]*Host, 0, len(inventoryHosts))
+	for _, inventoryHost := range inventoryHosts {
+		host, err := inv.NewHost(inventoryHost.Name, resultChannel, errorChannel)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return NewMultipleHostsSshClient(hosts...), nil
+}
+
+// Creates the client of a host from its variables, visited are the hosts whose bastion is being created.
+func (inv *Inventory) newClient(name string, vars map[string]interface{}, visited map[string]bool) (*SshClient, error) {
+	address := stringVar(vars, addressVars, name)
+	userName := stringVar(vars, userVars, "")
+	if userName == "" {
+		userName = currentUser()
+	}
+	port, err := intVar(vars, portVars, 22)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port of host %s: %s", name, err.Error())
+	}
+	client, err := newClientWithCredentials(name, address, userName, vars)
+	if err != nil {
+		return nil, err
+	}
+	client.Port = port
+
+	bastion := stringVar(vars, bastionVars, "")
+	if bastion == "" {
+		return client, nil
+	}
+	visited[name] = true
+	if inv.hosts[bastion] != nil {
+		if visited[bastion] {
+			return nil, errors.New("The bastions of host " + name + " form a cycle")
+		}
+		client.Bastion, err = inv.newClient(bastion, inv.HostVars(bastion), visited)
+		return client, err
+	}
+	bastionUser, bastionAddress, bastionPort, err := splitSshAddress(bastion, userName, 22)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid bastion of host %s: %s", name, err.Error())
+	}
+	if client.Bastion, err = newClientWithCredentials(name, bastionAddress, bastionUser, vars); err != nil {
+		return nil, err
+	}
+	client.Bastion.Port = bastionPort
+	return client, nil
+}
+
+// Creates a client authenticated with the key or the password in the variables of the host.
+func newClientWithCredentials(name string, address string, userName string,
+	vars map[string]interface{}) (*SshClient, error) {
+	if key := stringVar(vars, keyVars, ""); key != "" {
+		if strings.HasPrefix(key, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				key = filepath.Join(home, key[2:])
+			}
+		}
+		client, err := NewSshClient(address, userName, KeyAuthentication, key)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key of host %s: %s", name, err.Error())
+		}
+		return client, nil
+	}
+	if password := stringVar(vars, passwordVars, ""); password != "" {
+		return NewSshClient(address, userName, PasswordAuthentication, password)
+	}
+	return nil, errors.New("Host " + name + " has neither a key nor a password")
+}
+
+// Splits an address like "user@host:port" or "user@[ipv6]:port", the user and the port are optional.
+// IPv6 addresses without brackets can't have a port.
+func splitSshAddress(address string, defaultUser string, defaultPort int) (string, string, int, error) {
+	userName := defaultUser
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		userName, address = address[:at], address[at+1:]
+	}
+	address, port, err := splitINIHostPort(address)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if port == 0 {
+		port = defaultPort
+	}
+	if address == "" {
+		return "", "", 0, errors.New("empty address")
+	}
+	return userName, address, port, nil
+}
+
+func currentUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Returns the first of the variables which is set, as a string.
+func stringVar(vars map[string]interface{}, names []string, defaultValue string) string {
+	for _, name := range names {
+		if value, ok := vars[name]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+	}
+	return defaultValue
+}
+
+// Returns the first of the variables which is set, as an int.
+func intVar(vars map[string]interface{}, names []string, defaultValue int) (int, error) {
+	for _, name := range names {
+		switch value := vars[name].(type) {
+		case nil:
+			continue
+		case int:
+			return value, nil
+		case float64:
+			if value == float64(int(value)) {
+				return int(value), nil
+			}
+		case string:
+			return strconv.Atoi(value)
+		}
+		return 0, fmt.Errorf("%v isn't a number", vars[name])
+	}
+	return defaultValue, nil
+}
+
+// Returns the host with the given name, adding it if it isn't in the inventory yet.
+func (inv *Inventory) addHost(name string) *InventoryHost {
+	host := inv.hosts[name]
+	if host == nil {
+		host = &InventoryHost{Name: name, Vars: make(map[string]interface{})}
+		inv.hosts[name] = host
+		inv.Hosts = append(inv.Hosts, host)
+	}
+	return host
+}
+
+// Returns the group with the given name, adding it if it isn't in the inventory yet.
+func (inv *Inventory) addGroup(name string) *InventoryGroup {
+	group := inv.groups[name]
+	if group == nil {
+		group = &InventoryGroup{Name: name, Vars: make(map[string]interface{})}
+		inv.groups[name] = group
+		inv.Groups = append(inv.Groups, group)
+	}
+	return group
+}
+
+func (inv *Inventory) addHostToGroup(groupName string, host *InventoryHost) {
+	group := inv.addGroup(groupName)
+	if !containsString(group.Hosts, host.Name) {
+		group.Hosts = append(group.Hosts, host.Name)
+		host.Groups = append(host.Groups, groupName)
+	}
+}
+
+func (inv *Inventory) addChild(parentName string, childName string) {
+	parent := inv.addGroup(parentName)
+	inv.addGroup(childName)
+	if !containsString(parent.Children, childName) {
+		parent.Children = append(parent.Children, childName)
+	}
+}
+
+// Checks the children of the groups for cycles and adds the groups "all" and "ungrouped".
+func (inv *Inventory) finish() error {
+	for _, group := range inv.Groups {
+		if err := inv.checkCycle(group.Name, map[string]bool{}); err != nil {
+			return err
+		}
+	}
+	inv.addGroup("all")
+	inv.addGroup("ungrouped")
+	for _, group := range inv.Groups {
+		if group.Name != "all" && len(inv.parents(group.Name)) == 0 {
+			inv.addChild("all", group.Name)
+		}
+	}
+	for _, host := range inv.Hosts {
+		if len(host.Groups) == 0 || len(host.Groups) == 1 && host.Groups[0] == "all" {
+			inv.addHostToGroup("ungrouped", host)
+		}
+	}
+	return nil
+}
+
+func (inv *Inventory) checkCycle(name string, path map[string]bool) error {
+	if path[name] {
+		return errors.New("Group " + name + " is a child of itself")
+	}
+	path[name] = true
+	for _, child := range inv.groups[name].Children {
+		if err := inv.checkCycle(child, path); err != nil {
+			return err
+		}
+	}
+	delete(path, name)
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
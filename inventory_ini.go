@@ -0,0 +1,193 @@
+package gosher
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Parses an Ansible INI inventory into inv.
+// Host lines before the first section are ungrouped, host names can have ranges, see ExpandHostRange.
+func (inv *Inventory) parseINI(content string) error {
+	group := ""
+	sectionType := "hosts"
+	for number, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		var err error
+		if line[0] == '[' && !isINIAddress(line) {
+			group, sectionType, err = parseINISection(line)
+			if err == nil {
+				inv.addGroup(group)
+			}
+		} else {
+			err = inv.parseINILine(line, group, sectionType)
+		}
+		if err != nil {
+			return errors.New("Line " + strconv.Itoa(number+1) + " of the inventory: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Returns the group of a section header like [group], [group:vars] or [group:children] and the type of the section.
+func parseINISection(line string) (string, string, error) {
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return "", "", errors.New("unterminated section " + line)
+	}
+	if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", "", errors.New("unexpected " + rest + " after the section")
+	}
+	name := strings.TrimSpace(line[1:end])
+	sectionType := "hosts"
+	if colon := strings.LastIndex(name, ":"); colon >= 0 {
+		name, sectionType = name[:colon], name[colon+1:]
+		if sectionType != "vars" && sectionType != "children" {
+			return "", "", errors.New("unknown section type " + sectionType)
+		}
+	}
+	if name == "" {
+		return "", "", errors.New("empty group name")
+	}
+	return name, sectionType, nil
+}
+
+func (inv *Inventory) parseINILine(line string, group string, sectionType string) error {
+	switch sectionType {
+	case "vars":
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			return errors.New("expected key=value, got " + line)
+		}
+		key := strings.TrimSpace(line[:equals])
+		inv.groups[group].Vars[key] = parseINIValue(strings.TrimSpace(line[equals+1:]))
+	case "children":
+		inv.addChild(group, strings.Fields(line)[0])
+	default:
+		tokens, err := splitINITokens(line)
+		if err != nil {
+			return err
+		}
+		names, err := expandINIHost(tokens[0])
+		if err != nil {
+			return err
+		}
+		vars := make(map[string]interface{})
+		for _, token := range tokens[1:] {
+			equals := strings.Index(token, "=")
+			if equals <= 0 {
+				return errors.New("expected key=value, got " + token)
+			}
+			vars[token[:equals]] = parseINIValue(token[equals+1:])
+		}
+		for _, name := range names {
+			name, port, err := splitINIHostPort(name)
+			if err != nil {
+				return err
+			}
+			host := inv.addHost(name)
+			if port != 0 {
+				host.Vars["ansible_port"] = port
+			}
+			for key, value := range vars {
+				host.Vars[key] = value
+			}
+			if group != "" {
+				inv.addHostToGroup(group, host)
+			}
+		}
+	}
+	return nil
+}
+
+// Splits a host line on whitespace outside of quotes, the rest of the line after a # token is a comment.
+func splitINITokens(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	var quote byte
+	inToken := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			token.WriteByte(c)
+		case c == ' ' || c == '\t':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		case c == '#' && !inToken:
+			return tokens, nil
+		default:
+			if c == '"' || c == '\'' {
+				quote = c
+			}
+			token.WriteByte(c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in " + line)
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+// Reports whether text starts with an IPv6 address in brackets like "[2001:db8::1]:2222",
+// which is neither a section nor a range.
+func isINIAddress(text string) bool {
+	end := strings.Index(text, "]")
+	return strings.HasPrefix(text, "[") && end > 0 && net.ParseIP(text[1:end]) != nil
+}
+
+// Expands the ranges of a host name, see ExpandHostRange.
+func expandINIHost(host string) ([]string, error) {
+	if isINIAddress(host) {
+		return []string{host}, nil
+	}
+	return ExpandHostRange(host)
+}
+
+// Splits a host like "host:port" or "[ipv6]:port", the port is 0 if it isn't set.
+// IPv6 addresses without brackets can't have a port.
+func splitINIHostPort(host string) (string, int, error) {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1], 0, nil
+	}
+	colon := strings.LastIndex(host, ":")
+	if colon < 0 || strings.Count(host, ":") > 1 && !strings.HasPrefix(host, "[") {
+		return host, 0, nil
+	}
+	port, err := strconv.Atoi(host[colon+1:])
+	if err != nil {
+		return "", 0, errors.New("invalid port in host " + host)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host[:colon], "["), "]"), port, nil
+}
+
+// Converts a value of the INI inventory: quoted values are strings, integers and booleans are converted.
+func parseINIValue(value string) interface{} {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	if number, err := strconv.Atoi(value); err == nil {
+		return number
+	}
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
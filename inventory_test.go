@@ -0,0 +1,204 @@
+package gosher
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+const testINIInventory = `
+# ungrouped
+jump.example.com ansible_user=admin password=secret
+
+[web]
+web-1 ansible_host=10.0.0.1 env="blue green" # comment
+web-2:2222 user=deploy
+
+[db]
+db-1 bastion=jump.example.com
+
+[prod:children]
+web
+db
+
+[prod:vars]
+password=prod-secret
+port=2200
+
+[web:vars]
+port=22
+`
+
+const testYAMLInventory = `
+all:
+  hosts:
+    jump.example.com:
+      ansible_user: admin
+      password: secret
+  children:
+    prod:
+      vars:
+        password: prod-secret
+        port: 2200
+      children:
+        web:
+          vars:
+            port: 22
+          hosts:
+            web-1:
+              ansible_host: 10.0.0.1
+              env: blue green
+            web-2:
+              ansible_port: 2222
+              user: deploy
+        db:
+          hosts:
+            db-1:
+              bastion: jump.example.com
+`
+
+const testJSONInventory = `{
+	"web": {"hosts": ["web-1", "web-2"], "vars": {"port": 22}},
+	"db": ["db-1"],
+	"prod": {"children": ["web", "db"], "vars": {"password": "prod-secret", "port": 2200}},
+	"ungrouped": {"hosts": ["jump.example.com"]},
+	"_meta": {"hostvars": {
+		"jump.example.com": {"ansible_user": "admin", "password": "secret"},
+		"web-1": {"ansible_host": "10.0.0.1", "env": "blue green"},
+		"web-2": {"ansible_port": 2222, "user": "deploy"},
+		"db-1": {"bastion": "jump.example.com"}
+	}}
+}`
+
+func TestParseInventory(t *testing.T) {
+	formats := map[string]int{
+		testINIInventory:  InventoryINI,
+		testYAMLInventory: InventoryYAML,
+		testJSONInventory: InventoryJSON,
+	}
+	for content, format := range formats {
+		inventory, err := ParseInventory([]byte(content), format)
+		if !assert.Nil(t, err, format) {
+			continue
+		}
+		hosts, err := inventory.GroupHosts("prod")
+		assert.Nil(t, err)
+		var names []string
+		for _, host := range hosts {
+			names = append(names, host.Name)
+		}
+		assert.ElementsMatch(t, []string{"web-1", "web-2", "db-1"}, names, format)
+		all, _ := inventory.GroupHosts("all")
+		assert.Equal(t, 4, len(all), format)
+		ungrouped, _ := inventory.GroupHosts("ungrouped")
+		assert.Equal(t, 1, len(ungrouped), format)
+
+		vars := inventory.HostVars("web-1")
+		assert.Equal(t, 22, vars["port"], "the variables of a child group should override its parent")
+		assert.Equal(t, "prod-secret", vars["password"])
+		assert.Equal(t, "blue green", vars["env"])
+		assert.Equal(t, 2222, inventory.HostVars("web-2")["ansible_port"])
+		assert.Equal(t, 2200, inventory.HostVars("db-1")["port"])
+
+		host, err := inventory.NewHost("db-1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "db-1", host.Alias)
+		assert.Equal(t, 2200, host.Client.Port)
+		assert.Equal(t, "jump.example.com", host.Client.Bastion.Address)
+		assert.Equal(t, "admin", host.Client.Bastion.clientConfiguration.User)
+
+		msc, err := inventory.NewMultipleHostsSshClient("web", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(msc.Hosts))
+		assert.Equal(t, "10.0.0.1", msc.Hosts[0].Client.Address)
+		assert.Equal(t, "deploy", msc.Hosts[1].Client.clientConfiguration.User)
+		assert.Equal(t, 2222, msc.Hosts[1].Client.Port)
+	}
+}
+
+func TestINIHostAddresses(t *testing.T) {
+	inventory, err := ParseInventory([]byte("[::1]:2222\n::2\n[2001:db8::3]\ndb[1:2]:2022\n"), InventoryINI)
+	assert.Nil(t, err)
+	assert.Equal(t, 2222, inventory.HostVars("::1")["ansible_port"])
+	assert.NotNil(t, inventory.Host("::2"))
+	assert.Nil(t, inventory.HostVars("::2")["ansible_port"])
+	assert.NotNil(t, inventory.Host("2001:db8::3"))
+	assert.Equal(t, 2022, inventory.HostVars("db2")["ansible_port"])
+	assert.Equal(t, 5, len(inventory.Hosts))
+}
+
+func TestDialIPv6InventoryHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 isn't available")
+	}
+	accepted := make(chan bool, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err == nil
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	inventory, err := ParseInventory([]byte(fmt.Sprintf("[v6]\n[::1]:%d password=x\n", port)), InventoryINI)
+	assert.Nil(t, err)
+	msc, err := inventory.NewMultipleHostsSshClient("v6", nil, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "::1", msc.Hosts[0].Client.Address)
+	_, err = msc.Hosts[0].Client.dial()
+	listener.Close()
+	assert.NotNil(t, err, "the handshake with a listener which isn't a server should fail")
+	assert.True(t, <-accepted, "the IPv6 host should be connected to")
+}
+
+func TestInventoryErrors(t *testing.T) {
+	_, err := ParseInventory([]byte("[a:children]\nb\n[b:children]\na\n"), InventoryINI)
+	assert.NotNil(t, err, "groups which are children of themselves should be rejected")
+	_, err = ParseInventory([]byte("[web\nhost"), InventoryINI)
+	assert.NotNil(t, err)
+
+	inventory, err := ParseInventory([]byte("a bastion=b password=x\nb bastion=a password=x\nc\n"), InventoryINI)
+	assert.Nil(t, err)
+	_, err = inventory.NewHost("a", nil, nil)
+	assert.NotNil(t, err, "bastions which form a cycle should be rejected")
+	_, err = inventory.NewHost("c", nil, nil)
+	assert.NotNil(t, err, "hosts without credentials should be rejected")
+	_, err = inventory.NewMultipleHostsSshClient("missing", nil, nil)
+	assert.NotNil(t, err)
+
+	inventory, err = ParseInventory([]byte("[web]\nweb-1 password=x\nweb-2 key=/missing/id_rsa\n"), InventoryINI)
+	assert.Nil(t, err)
+	_, err = inventory.NewMultipleHostsSshClient("web", nil, nil)
+	if assert.NotNil(t, err, "a group with an invalid host should be rejected") {
+		assert.Contains(t, err.Error(), "web-2", "the error should name the invalid host")
+	}
+}
+
+func TestBastionAddress(t *testing.T) {
+	inventory, err := ParseInventory([]byte("db password=x bastion=ops@jump:2022\n"), InventoryINI)
+	assert.Nil(t, err)
+	host, err := inventory.NewHost("db", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "jump", host.Client.Bastion.Address)
+	assert.Equal(t, "ops", host.Client.Bastion.clientConfiguration.User)
+	assert.Equal(t, 2022, host.Client.Bastion.Port)
+}
+
+func TestBastionIPv6Address(t *testing.T) {
+	addresses := map[string][]interface{}{
+		"2001:db8::1":          {"2001:db8::1", 22},
+		"ops@[2001:db8::1]:22": {"2001:db8::1", 22},
+		"[2001:db8::1]:2022":   {"2001:db8::1", 2022},
+	}
+	for bastion, expected := range addresses {
+		_, address, port, err := splitSshAddress(bastion, "deploy", 22)
+		assert.Nil(t, err, bastion)
+		assert.Equal(t, expected, []interface{}{address, port}, bastion)
+	}
+	_, _, _, err := splitSshAddress("jump:ssh", "deploy", 22)
+	assert.NotNil(t, err, "an invalid port should be rejected")
+}
@@ -0,0 +1,184 @@
+package gosher
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+)
+
+// Parses a YAML or JSON inventory into inv, JSON is parsed as YAML to keep the order of the hosts.
+// Every key at the top is a group, "_meta" can hold the variables of the hosts in "hostvars".
+func (inv *Inventory) parseStructured(content []byte) error {
+	var groups yaml.MapSlice
+	if err := yaml.Unmarshal(content, &groups); err != nil {
+		return err
+	}
+	var meta interface{}
+	for _, item := range groups {
+		name := fmt.Sprint(item.Key)
+		if name == "_meta" {
+			meta = item.Value
+			continue
+		}
+		if err := inv.parseStructuredGroup(name, item.Value); err != nil {
+			return err
+		}
+	}
+	if meta == nil {
+		return nil
+	}
+	metaMap, ok := meta.(yaml.MapSlice)
+	if !ok {
+		return errors.New("_meta has to be a map")
+	}
+	for _, item := range metaMap {
+		if fmt.Sprint(item.Key) != "hostvars" {
+			continue
+		}
+		hostVars, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return errors.New("_meta.hostvars has to be a map")
+		}
+		for _, hostItem := range hostVars {
+			vars, err := structuredVars(hostItem.Value)
+			if err != nil {
+				return errors.New("Host " + fmt.Sprint(hostItem.Key) + ": " + err.Error())
+			}
+			host := inv.addHost(fmt.Sprint(hostItem.Key))
+			for key, value := range vars {
+				host.Vars[key] = value
+			}
+		}
+	}
+	return nil
+}
+
+// Parses a group with hosts, vars and children, which are groups themselves,
+// or a list of the names of its hosts.
+func (inv *Inventory) parseStructuredGroup(name string, value interface{}) error {
+	inv.addGroup(name)
+	if hosts, isList := value.([]interface{}); isList {
+		return inv.parseStructuredHosts(name, hosts)
+	}
+	if value == nil {
+		return nil
+	}
+	fields, ok := value.(yaml.MapSlice)
+	if !ok {
+		return errors.New("Group " + name + " has to be a map or a list of hosts")
+	}
+	for _, field := range fields {
+		var err error
+		switch fmt.Sprint(field.Key) {
+		case "hosts":
+			err = inv.parseStructuredHosts(name, field.Value)
+		case "vars":
+			var vars map[string]interface{}
+			if vars, err = structuredVars(field.Value); err == nil {
+				for key, value := range vars {
+					inv.groups[name].Vars[key] = value
+				}
+			}
+		case "children":
+			err = inv.parseStructuredChildren(name, field.Value)
+		default:
+			err = errors.New("unknown key " + fmt.Sprint(field.Key))
+		}
+		if err != nil {
+			return errors.New("Group " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Parses the hosts of a group, a map from the names of the hosts to their variables or a list of names,
+// the names can have ranges, see ExpandHostRange.
+func (inv *Inventory) parseStructuredHosts(group string, value interface{}) error {
+	switch hosts := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range hosts {
+			names, err := ExpandHostRange(fmt.Sprint(item))
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				inv.addHostToGroup(group, inv.addHost(name))
+			}
+		}
+	case yaml.MapSlice:
+		for _, item := range hosts {
+			vars, err := structuredVars(item.Value)
+			if err != nil {
+				return errors.New("Host " + fmt.Sprint(item.Key) + ": " + err.Error())
+			}
+			names, err := ExpandHostRange(fmt.Sprint(item.Key))
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				host := inv.addHost(name)
+				for key, value := range vars {
+					host.Vars[key] = value
+				}
+				inv.addHostToGroup(group, host)
+			}
+		}
+	default:
+		return errors.New("hosts have to be a map or a list")
+	}
+	return nil
+}
+
+// Parses the children of a group, a map from their names to the groups or a list of names.
+func (inv *Inventory) parseStructuredChildren(group string, value interface{}) error {
+	switch children := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, name := range children {
+			inv.addChild(group, fmt.Sprint(name))
+		}
+	case yaml.MapSlice:
+		for _, item := range children {
+			name := fmt.Sprint(item.Key)
+			inv.addChild(group, name)
+			if err := inv.parseStructuredGroup(name, item.Value); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("children have to be a map or a list")
+	}
+	return nil
+}
+
+// Converts a map of variables, nested maps are converted to map[string]interface{}.
+func structuredVars(value interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	if value == nil {
+		return vars, nil
+	}
+	items, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("variables have to be a map")
+	}
+	for _, item := range items {
+		vars[fmt.Sprint(item.Key)] = structuredValue(item.Value)
+	}
+	return vars, nil
+}
+
+func structuredValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case yaml.MapSlice:
+		vars, _ := structuredVars(typed)
+		return vars
+	case []interface{}:
+		values := make([]interface{}, len(typed))
+		for i, item := range typed {
+			values[i] = structuredValue(item)
+		}
+		return values
+	}
+	return value
+}
@@ -0,0 +1,139 @@
+package gosher
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+)
+
+// The ANSI colors of the hosts with LiveOutput.Colors, one per host in turn.
+var liveColors = []int{36, 33, 32, 35, 34, 96, 93, 92, 95, 94}
+
+// LiveOutput - writes the output of the commands on multiple hosts line by line as it arrives,
+// every line prefixed with the host it comes from like pdsh, e.g. "web-1: GET /index.html".
+// Lines of different hosts aren't mixed, a line without a newline at the end is written when the command ends.
+// Writer - os.Stdout by default, where the lines are written.
+// Stderr - nil by default (stderr is written to Writer as well), if set the lines of stderr are written to it.
+// The lines of stdout and stderr are split separately, so a partial line of one isn't joined with the other.
+// Prefix - "{{.Alias}}: " by default, the text/template of the prefix rendered with the Address, Alias,
+// Index and Vars of the host, see TemplateData. The prefixes are padded to the same width.
+// Colors - false by default, if true the prefixes are colored with a different color for every host.
+// The errors of the writers are ignored, they don't fail the commands.
+type LiveOutput struct {
+	Writer io.Writer
+	Stderr io.Writer
+	Prefix string
+	Colors bool
+	mutex  sync.Mutex
+}
+
+// Returns the prefix of every host, padded to the same width and colored with Colors.
+func (lo *LiveOutput) prefixes(msc *MultipleHostsSshClient) ([]string, error) {
+	prefix := lo.Prefix
+	if prefix == "" {
+		prefix = "{{.Alias}}: "
+	}
+	prefixTemplate, err := template.New("prefix").Option("missingkey=error").Parse(prefix)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := make([]string, len(msc.Hosts))
+	width := 0
+	for i := range msc.Hosts {
+		data := msc.templateData(i)
+		data.client = msc.Hosts[i].Client
+		var rendered bytes.Buffer
+		if err = prefixTemplate.Execute(&rendered, data); err != nil {
+			return nil, err
+		}
+		prefixes[i] = rendered.String()
+		if length := utf8.RuneCountInString(prefixes[i]); length > width {
+			width = length
+		}
+	}
+	for i, prefix := range prefixes {
+		padding := strings.Repeat(" ", width-utf8.RuneCountInString(prefix))
+		if lo.Colors {
+			color := strconv.Itoa(liveColors[i%len(liveColors)])
+			prefix = "\x1b[" + color + "m" + prefix + "\x1b[0m"
+		}
+		prefixes[i] = prefix + padding
+	}
+	return prefixes, nil
+}
+
+// Returns a task which executes task with a client writing its output to lo.
+func (lo *LiveOutput) hostTask(msc *MultipleHostsSshClient, task hostTask) hostTask {
+	prefixes, err := lo.prefixes(msc)
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		if err != nil {
+			return nil, err
+		}
+		if client == msc.Hosts[index].Client {
+			client = client.clone()
+			defer client.closeClone()
+		}
+		stdout := lo.lineWriter(lo.Writer, prefixes[index])
+		stderr := lo.lineWriter(lo.Writer, prefixes[index])
+		if lo.Stderr != nil {
+			stderr.writer = lo.Stderr
+		}
+		client.Stdout, client.Stderr = stdout, stderr
+		defer stdout.flush()
+		defer stderr.flush()
+		return task(index, client)
+	}
+}
+
+func (lo *LiveOutput) lineWriter(writer io.Writer, prefix string) *liveLineWriter {
+	if writer == nil {
+		writer = os.Stdout
+	}
+	return &liveLineWriter{output: lo, writer: writer, prefix: prefix}
+}
+
+// Writes a line with its prefix, lines are written one at a time.
+func (lo *LiveOutput) writeLine(writer io.Writer, prefix string, line []byte) {
+	lo.mutex.Lock()
+	defer lo.mutex.Unlock()
+	io.WriteString(writer, prefix+string(line))
+}
+
+// liveLineWriter - the output of a host, which is split into lines written to the LiveOutput.
+type liveLineWriter struct {
+	output  *LiveOutput
+	writer  io.Writer
+	prefix  string
+	mutex   sync.Mutex
+	partial []byte
+}
+
+func (lw *liveLineWriter) Write(p []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	lw.partial = append(lw.partial, p...)
+	for {
+		end := bytes.IndexByte(lw.partial, '\n')
+		if end < 0 {
+			break
+		}
+		lw.output.writeLine(lw.writer, lw.prefix, lw.partial[:end+1])
+		lw.partial = lw.partial[end+1:]
+	}
+	return len(p), nil
+}
+
+// Writes the rest of the output, which doesn't end with a newline.
+func (lw *liveLineWriter) flush() {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if len(lw.partial) > 0 {
+		lw.output.writeLine(lw.writer, lw.prefix, append(lw.partial, '\n'))
+		lw.partial = nil
+	}
+}
@@ -0,0 +1,47 @@
+package gosher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLiveOutput(t *testing.T) {
+	msc := newTestHosts("10.0.0.1", "10.0.0.2")
+	msc.Hosts[0].Alias = "web-1"
+	var stdout, stderr bytes.Buffer
+	msc.LiveOutput = &LiveOutput{Writer: &stdout}
+	msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		io.WriteString(client.Stdout, "first\nsec")
+		io.WriteString(client.Stderr, "warning\n")
+		io.WriteString(client.Stdout, "ond\nno newline")
+		return &SshResponse{}, nil
+	})
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	assert.Equal(t, 8, len(lines))
+	assert.Contains(t, lines, "web-1:    first", "the prefixes should be aligned")
+	assert.Contains(t, lines, "10.0.0.2: second")
+	assert.Contains(t, lines, "10.0.0.2: no newline")
+	assert.Contains(t, lines, "web-1:    warning")
+	assert.Nil(t, msc.Hosts[0].Client.Stdout, "the clients of the hosts shouldn't change")
+
+	stdout.Reset()
+	msc.LiveOutput = &LiveOutput{Writer: &stdout, Stderr: &stderr, Prefix: "[{{.Index}}] ", Colors: true}
+	msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		io.WriteString(client.Stdout, "out\n")
+		io.WriteString(client.Stderr, "err\n")
+		return &SshResponse{}, nil
+	})
+	assert.Contains(t, stdout.String(), "\x1b[36m[0] \x1b[0mout\n")
+	assert.Contains(t, stdout.String(), "\x1b[33m[1] \x1b[0mout\n")
+	assert.NotContains(t, stdout.String(), "err")
+	assert.Contains(t, stderr.String(), "\x1b[36m[0] \x1b[0merr\n")
+
+	msc.LiveOutput = &LiveOutput{Writer: &stdout, Prefix: "{{.Vars.missing}}"}
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		return &SshResponse{}, nil
+	})
+	assert.NotNil(t, results[0].Err, "an invalid prefix should fail the hosts")
+}
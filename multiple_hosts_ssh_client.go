@@ -1,9 +1,53 @@
 package gosher
 
-import "strconv"
+import (
+	"context"
+	"io"
+	"os"
+)
 
+// ProgressCallback - nil by default, if set it is invoked with the aggregated
+// progress of all hosts during Upload and Download
+// DownloadNaming - NamingByIndex by default, the template of the local path every host
+// downloads to, see DownloadNamingData. A path ending with a slash is a directory which
+// is created and downloaded into.
+// CheckMode - false by default, if true the operations which change remote files only report
+// what would change on every host without writing anything, see SshClient.CheckMode
+// Parallelism - 0 (no limit) by default, the maximum number of hosts an operation runs on at the same time,
+// BroadcastOptions.Concurrency can limit a Broadcast further
+// Serial - empty by default (all hosts at once), rolls operations out in batches of a number of hosts
+// like "2" or a percentage of the hosts like "25%", a batch starts only after the previous one has finished
+// MaxFailPercentage - 0 by default, the percentage of the hosts of a batch which can fail, if more of them
+// fail the rollout is aborted and the remaining hosts are skipped with a HostSkippedError
+// FailFast - false by default, if true the first failed host cancels the operation on the hosts
+// where it is running and the hosts where it hasn't started are skipped
+// RetryPolicy - nil (no retries) by default, if set the operation is retried on every host by it
+// instead of by the RetryPolicy of the client of the host
+// Canary - empty by default, a number of hosts like "1" or a percentage like "10%" of the first hosts
+// which run the operation before the others, if it fails on any of them the others are skipped
+// CanaryCheck - nil by default (the operation succeeded if it has no error), decides whether
+// the operation succeeded on a canary, e.g. by the output of its SshResponse, instead of
+// its error and MaxFailPercentage
+// Quorum - 0 (disabled) by default, the number of hosts the operation has to succeed on, once it has
+// the operation is cancelled on the other hosts and they are skipped, when it can't succeed on enough hosts
+// anymore it is cancelled as well, see QuorumReached
+// LiveOutput - nil by default, if set the output of the commands on the hosts is written to it
+// line by line as it arrives, prefixed with the host
 type MultipleHostsSshClient struct {
-	Hosts []*Host
+	Hosts             []*Host
+	ProgressCallback  MultipleHostsProgressCallback
+	DownloadNaming    string
+	CheckMode         bool
+	Parallelism       int
+	Serial            string
+	MaxFailPercentage int
+	FailFast          bool
+	RetryPolicy       *RetryPolicy
+	Canary            string
+	CanaryCheck       func(result HostResult) bool
+	Quorum            int
+	LiveOutput        *LiveOutput
+	context           context.Context
 }
 
 // Constructor method for MultipleHostsSshClient
@@ -14,81 +58,186 @@ func NewMultipleHostsSshClient(hosts ...*Host) *MultipleHostsSshClient {
 	}
 }
 
+// Returns a copy of the client whose operations are bound to ctx, see SshClient.WithContext.
+// When ctx is done the operations running on the hosts fail and the hosts where they
+// haven't started are skipped with a HostSkippedError.
+func (msc *MultipleHostsSshClient) WithContext(ctx context.Context) *MultipleHostsSshClient {
+	client := *msc
+	client.context = ctx
+	return &client
+}
+
 // Executes shell command on all hosts in a separate goroutine for each.
 // The result from execution is passed via the hosts' channels
+// Use RunAll to wait for the results instead.
 func (msc *MultipleHostsSshClient) Run(command string) {
-	for i := range msc.Hosts {
-		go func(i int) {
-			result, err := msc.Hosts[i].Client.Run(command)
-			if err != nil {
-				msc.Hosts[i].ErrorChannel <- err
-				return
-			}
-			msc.Hosts[i].ResultChannel <- result
-		}(i)
-	}
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.Run(command)
+	})
 }
 
 // Executes shell script on all hosts in a separate goroutine for each.
 // The result from execution is passed via the hosts' channels
 func (msc *MultipleHostsSshClient) RunScript(filePath string) {
-	for i := range msc.Hosts {
-		go func(i int) {
-			result, err := msc.Hosts[i].Client.RunScript(filePath)
-			if err != nil {
-				msc.Hosts[i].ErrorChannel <- err
-				return
-			}
-			msc.Hosts[i].ResultChannel <- result
-		}(i)
-	}
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.RunScript(filePath)
+	})
 }
 
 // Uploads a file/folder to all hosts of the MultipleHostsSshClient.
 // The sshResponse is passed via the channels of the hosts
 func (msc *MultipleHostsSshClient) Upload(localPath string, remotePath string) {
-	for i := range msc.Hosts {
-		go func(i int) {
-			result, err := msc.Hosts[i].Client.Upload(localPath, remotePath)
-			if err != nil {
-				msc.Hosts[i].ErrorChannel <- err
-				return
-			}
-			msc.Hosts[i].ResultChannel <- result
-		}(i)
+	msc.UploadWithOptions(localPath, remotePath, TransferOptions{})
+}
+
+// Uploads a file/folder to all hosts of the MultipleHostsSshClient with the given TransferOptions.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) UploadWithOptions(localPath string, remotePath string, options TransferOptions) {
+	msc.forEachHost(msc.uploadTask(localPath, remotePath, options))
+}
+
+func (msc *MultipleHostsSshClient) uploadTask(localPath string, remotePath string, options TransferOptions) hostTask {
+	aggregator := newProgressAggregator(msc.ProgressCallback)
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		return client.uploadWithProgress(localPath, remotePath, options, aggregator.callbackFor(index, client))
 	}
 }
 
 // Downloads files/folders from all hosts of the MultipleHostsSshClient's list.
-// They will be suffixed with the index of the host they are downloaded from
+// They are named by the DownloadNaming template, by default suffixed with the index of the host
+// they are downloaded from
 func (msc *MultipleHostsSshClient) Download(remotePath string, localPath string) {
-	for i, host := range msc.Hosts {
-		go func(i int) {
-			suffixedDownloadPath := localPath + strconv.Itoa(i)
-			result, err := msc.Hosts[i].Client.Download(remotePath, suffixedDownloadPath)
-			if err != nil {
-				host.ErrorChannel <- err
-				return
-			}
-			host.ResultChannel <- result
-		}(i)
+	msc.DownloadWithOptions(remotePath, localPath, TransferOptions{})
+}
+
+// Downloads files/folders from all hosts of the MultipleHostsSshClient's list with the given TransferOptions.
+// They are named by the DownloadNaming template, by default suffixed with the index of the host
+// they are downloaded from
+func (msc *MultipleHostsSshClient) DownloadWithOptions(remotePath string, localPath string, options TransferOptions) {
+	msc.forEachHost(msc.downloadTask(remotePath, localPath, options))
+}
+
+func (msc *MultipleHostsSshClient) downloadTask(remotePath string, localPath string, options TransferOptions) hostTask {
+	aggregator := newProgressAggregator(msc.ProgressCallback)
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		hostDownloadPath, err := msc.downloadPath(index, localPath)
+		if err != nil {
+			return nil, err
+		}
+		return client.downloadWithProgress(remotePath, hostDownloadPath, options, aggregator.callbackFor(index, client))
 	}
 }
 
+// Renders the template at templatePath for every host with its address, alias, Vars and facts
+// and uploads the result to remotePath with the given mode, see SshClient.UploadTemplate.
+// The sshResponse, with Changed set if the remote file was changed, is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) UploadTemplate(templatePath string, remotePath string, mode os.FileMode) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.uploadTemplate(templatePath, remotePath, msc.templateData(index), mode)
+	})
+}
+
 // Executes an function on a remote text file on all hosts.
 // Can be used as an alternative of executing sed or awk on the remote machine.
 // alterContentsFunction - the function to be executed, the content of the file as string will be
 // passed to it and it should return the modified content.
 func (msc *MultipleHostsSshClient) RunOnFile(filePath string,
 	alterContentsFunction func(fileContent string) string) {
-	for i := range msc.Hosts {
-		go func(i int) {
-			result, err := msc.Hosts[i].Client.RunOnFile(filePath, alterContentsFunction)
-			if err != nil {
-				msc.Hosts[i].ErrorChannel <- err
-				return
-			}
-			msc.Hosts[i].ResultChannel <- result
-		}(i)
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.RunOnFile(filePath, alterContentsFunction)
+	})
+}
+
+// Executes a function on the contents of a remote file, which can be binary, on all hosts,
+// see SshClient.RunOnFileBytes.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) RunOnFileBytes(filePath string,
+	transformFunction func(content []byte) ([]byte, error)) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.RunOnFileBytes(filePath, transformFunction)
+	})
+}
+
+// Executes a function on a remote file streaming its contents on all hosts,
+// see SshClient.RunOnFileStream.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) RunOnFileStream(filePath string,
+	transformFunction func(reader io.Reader, writer io.Writer) error) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.RunOnFileStream(filePath, transformFunction)
+	})
+}
+
+// Ensures line is present in the remote file on all hosts, see SshClient.EnsureLine.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) EnsureLine(filePath string, line string) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.EnsureLine(filePath, line)
+	})
+}
+
+// Ensures line is present in the remote file on all hosts with the given EditOptions,
+// see SshClient.EnsureLineWithOptions.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) EnsureLineWithOptions(filePath string, line string, options EditOptions) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.EnsureLineWithOptions(filePath, line, options)
+	})
+}
+
+// Replaces the matches of expression in every line of the remote file on all hosts,
+// see SshClient.ReplaceRegexp.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) ReplaceRegexp(filePath string, expression string, replacement string) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.ReplaceRegexp(filePath, expression, replacement)
+	})
+}
+
+// Ensures the block between the markers of the remote file on all hosts, see SshClient.ManagedBlock.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) ManagedBlock(filePath string, beginMarker string, endMarker string,
+	block string) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.ManagedBlock(filePath, beginMarker, endMarker, block)
+	})
+}
+
+// Ensures the block between the markers of the remote file on all hosts with the given EditOptions,
+// see SshClient.ManagedBlockWithOptions.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) ManagedBlockWithOptions(filePath string, beginMarker string, endMarker string,
+	block string, options EditOptions) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.ManagedBlockWithOptions(filePath, beginMarker, endMarker, block, options)
+	})
+}
+
+// Deletes the lines of the remote file matching expression on all hosts, see SshClient.DeleteLines.
+// The sshResponse is passed via the channels of the hosts
+func (msc *MultipleHostsSshClient) DeleteLines(filePath string, expression string) {
+	msc.forEachHost(func(index int, client *SshClient) (*SshResponse, error) {
+		return client.DeleteLines(filePath, expression)
+	})
+}
+
+// Executes operation on all hosts in a separate goroutine for each
+// and passes the result via the hosts' channels.
+func (msc *MultipleHostsSshClient) forEachHost(task hostTask) {
+	go func() {
+		for result := range msc.execute(task) {
+			msc.deliver(result)
+		}
+	}()
+}
+
+// Returns the client of the host with the given index, in CheckMode a copy of it
+// which doesn't write anything.
+func (msc *MultipleHostsSshClient) client(index int) *SshClient {
+	client := msc.Hosts[index].Client
+	if msc.CheckMode && !client.CheckMode {
+		client = client.clone()
+		client.CheckMode = true
 	}
+	return client
 }
@@ -0,0 +1,173 @@
+package gosher
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathFilter decides which paths of a folder take part in a transfer
+// by gitignore-style include and exclude patterns:
+// patterns without a slash match a name at any depth, patterns with a slash are
+// relative to the transferred folder, * and ? don't match slashes, ** matches
+// any number of directories, a trailing slash matches only directories and
+// a leading ! negates a pattern, the last matching pattern wins.
+// Excluding a directory excludes everything in it, including a directory
+// includes everything in it. A nil pathFilter allows everything.
+type pathFilter struct {
+	include []filterPattern
+	exclude []filterPattern
+}
+
+type filterPattern struct {
+	expression    *regexp.Regexp
+	negated       bool
+	directoryOnly bool
+}
+
+// Returns nil if there are no patterns.
+func newPathFilter(include []string, exclude []string) (*pathFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	includePatterns, err := compileFilterPatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	excludePatterns, err := compileFilterPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &pathFilter{
+		include: includePatterns,
+		exclude: excludePatterns,
+	}, nil
+}
+
+// Reports whether a directory, given by its slash separated path relative
+// to the transferred folder, should be transferred.
+func (pf *pathFilter) allowsDirectory(relativePath string) bool {
+	return pf == nil || !pf.excluded(relativePath, true)
+}
+
+// Reports whether a file, given by its slash separated path relative
+// to the transferred folder, should be transferred.
+func (pf *pathFilter) allowsFile(relativePath string) bool {
+	if pf == nil {
+		return true
+	}
+	if pf.excluded(relativePath, false) {
+		return false
+	}
+	return len(pf.include) == 0 || pf.included(relativePath)
+}
+
+// Reports whether only the included paths are transferred, in which case
+// directories without any included contents are left out.
+func (pf *pathFilter) hasIncludes() bool {
+	return pf != nil && len(pf.include) > 0
+}
+
+// Reports whether the path or any of its parent directories is excluded.
+func (pf *pathFilter) excluded(relativePath string, isDirectory bool) bool {
+	for current := relativePath; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		if matchFilterPatterns(pf.exclude, current, isDirectory || current != relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the file or any of its parent directories is included.
+func (pf *pathFilter) included(relativePath string) bool {
+	for current := relativePath; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		if matchFilterPatterns(pf.include, current, current != relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the result of the last pattern matching the path, false if none of them matches.
+func matchFilterPatterns(patterns []filterPattern, relativePath string, isDirectory bool) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if pattern.directoryOnly && !isDirectory {
+			continue
+		}
+		if pattern.expression.MatchString(relativePath) {
+			matched = !pattern.negated
+		}
+	}
+	return matched
+}
+
+func compileFilterPatterns(patterns []string) ([]filterPattern, error) {
+	var compiled []filterPattern
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, " ")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		filter := filterPattern{}
+		if strings.HasPrefix(pattern, "!") {
+			filter.negated = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			filter.directoryOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		anchored := strings.Contains(pattern, "/")
+		pattern = strings.TrimPrefix(pattern, "/")
+		expression := "^"
+		if !anchored {
+			expression += "(?:.*/)?"
+		}
+		expression += globToRegexp(pattern) + "$"
+		compiledExpression, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		filter.expression = compiledExpression
+		compiled = append(compiled, filter)
+	}
+	return compiled, nil
+}
+
+// Translates a gitignore glob to a regular expression.
+func globToRegexp(glob string) string {
+	var expression strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expression.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expression.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			expression.WriteString("[^/]*")
+		case glob[i] == '?':
+			expression.WriteString("[^/]")
+		case glob[i] == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expression.WriteString(regexp.QuoteMeta(glob[i:]))
+				return expression.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case glob[i] == '\\' && i+1 < len(glob):
+			expression.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			expression.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return expression.String()
+}
@@ -0,0 +1,34 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPathFilterExclude(t *testing.T) {
+	filter, err := newPathFilter(nil, []string{".git/", "node_modules", "*.swp", "/build", "docs/**/*.tmp", "!keep.swp"})
+	assert.Nil(t, err, "newPathFilter returned an error")
+	assert.False(t, filter.allowsDirectory(".git"), ".git should be excluded")
+	assert.False(t, filter.allowsFile(".git/objects/ab"), "files in .git should be excluded")
+	assert.True(t, filter.allowsFile(".gitignore"), ".gitignore should be allowed")
+	assert.False(t, filter.allowsFile("web/node_modules/x/index.js"), "nested node_modules should be excluded")
+	assert.False(t, filter.allowsFile("src/.main.go.swp"), "swap files should be excluded")
+	assert.True(t, filter.allowsFile("src/keep.swp"), "negated pattern should allow the file")
+	assert.False(t, filter.allowsDirectory("build"), "anchored build should be excluded")
+	assert.True(t, filter.allowsDirectory("src/build"), "anchored pattern shouldn't match nested build")
+	assert.False(t, filter.allowsFile("docs/a/b/c.tmp"), "** should match nested directories")
+	assert.False(t, filter.allowsFile("docs/c.tmp"), "** should match no directories")
+	assert.True(t, filter.allowsFile("src/c.tmp"), "docs pattern shouldn't match outside docs")
+}
+
+func TestPathFilterInclude(t *testing.T) {
+	filter, err := newPathFilter([]string{"*.go", "config/"}, []string{"vendor/"})
+	assert.Nil(t, err, "newPathFilter returned an error")
+	assert.True(t, filter.allowsFile("main.go"), "go files should be included")
+	assert.True(t, filter.allowsFile("cmd/tool/main.go"), "nested go files should be included")
+	assert.False(t, filter.allowsFile("README.md"), "other files shouldn't be included")
+	assert.True(t, filter.allowsFile("config/app.yaml"), "contents of an included directory should be included")
+	assert.False(t, filter.allowsFile("vendor/lib/lib.go"), "exclude should win over include")
+	var nilFilter *pathFilter
+	assert.True(t, nilFilter.allowsFile("anything"), "nil filter should allow everything")
+}
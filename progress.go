@@ -0,0 +1,214 @@
+package gosher
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// How often the progress callback is invoked while a file is being transferred.
+const progressReportInterval = 100 * time.Millisecond
+
+// TransferProgress - snapshot of an ongoing upload or download.
+// TotalBytes is the size of everything that is going to be transferred,
+// for folder downloads it only includes the files announced by the remote so far.
+// Rate is in bytes per second, ETA is zero when it can't be estimated.
+type TransferProgress struct {
+	Address          string
+	File             string
+	BytesTransferred int64
+	TotalBytes       int64
+	Rate             float64
+	ETA              time.Duration
+}
+
+// ProgressCallback is invoked periodically during Upload and Download
+// and once after each transferred file.
+type ProgressCallback func(progress TransferProgress)
+
+// MultipleHostsProgress - aggregated progress of a transfer on multiple hosts.
+// Hosts holds the last reported progress of every host by its index in the Hosts
+// of the MultipleHostsSshClient, so hosts with the same address are kept apart.
+type MultipleHostsProgress struct {
+	Hosts            map[int]TransferProgress
+	BytesTransferred int64
+	TotalBytes       int64
+}
+
+// MultipleHostsProgressCallback is invoked every time one of the hosts reports progress.
+type MultipleHostsProgressCallback func(progress MultipleHostsProgress)
+
+// transferMeter counts the transferred bytes, reports them to the progress callback
+// and throttles the transfer to the bandwidth limit (in bytes per second, 0 means unlimited).
+// A nil transferMeter does nothing.
+type transferMeter struct {
+	progress       ProgressCallback
+	bandwidthLimit int64
+	current        TransferProgress
+	startTime      time.Time
+	lastReport     time.Time
+}
+
+func (s *SshClient) newTransferMeter(progress ProgressCallback) *transferMeter {
+	if progress == nil && s.BandwidthLimit <= 0 {
+		return nil
+	}
+	return &transferMeter{
+		progress:       progress,
+		bandwidthLimit: s.BandwidthLimit,
+		current:        TransferProgress{Address: s.Address},
+		startTime:      time.Now(),
+	}
+}
+
+// Marks name as the file being transferred.
+func (m *transferMeter) startFile(name string) {
+	if m == nil {
+		return
+	}
+	m.current.File = name
+	m.report(true)
+}
+
+func (m *transferMeter) addTotal(size int64) {
+	if m == nil {
+		return
+	}
+	m.current.TotalBytes += size
+}
+
+// Registers n transferred bytes, sleeping if the bandwidth limit is exceeded.
+func (m *transferMeter) transferred(n int) {
+	if m == nil {
+		return
+	}
+	m.current.BytesTransferred += int64(n)
+	if m.bandwidthLimit > 0 {
+		expected := time.Duration(float64(m.current.BytesTransferred) / float64(m.bandwidthLimit) * float64(time.Second))
+		if elapsed := time.Since(m.startTime); expected > elapsed {
+			time.Sleep(expected - elapsed)
+		}
+	}
+	m.report(false)
+}
+
+func (m *transferMeter) finishFile() {
+	m.report(true)
+}
+
+func (m *transferMeter) report(force bool) {
+	if m == nil || m.progress == nil {
+		return
+	}
+	now := time.Now()
+	if !force && now.Sub(m.lastReport) < progressReportInterval {
+		return
+	}
+	m.lastReport = now
+	elapsed := now.Sub(m.startTime).Seconds()
+	m.current.Rate = 0
+	m.current.ETA = 0
+	if elapsed > 0 {
+		m.current.Rate = float64(m.current.BytesTransferred) / elapsed
+	}
+	remaining := m.current.TotalBytes - m.current.BytesTransferred
+	if m.current.Rate > 0 && remaining > 0 {
+		m.current.ETA = time.Duration(float64(remaining) / m.current.Rate * float64(time.Second))
+	}
+	m.progress(m.current)
+}
+
+// Wraps w so that everything written to it is measured and throttled.
+func (m *transferMeter) writer(w io.Writer) io.Writer {
+	if m == nil {
+		return w
+	}
+	return &meteredWriter{writer: w, meter: m}
+}
+
+type meteredWriter struct {
+	writer io.Writer
+	meter  *transferMeter
+}
+
+// Writes in chunks of at most a tenth of the bandwidth limit so throttling stays smooth.
+func (mw *meteredWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if limit := int(mw.meter.bandwidthLimit / 10); limit > 0 && len(chunk) > limit {
+			chunk = chunk[:limit]
+		}
+		n, err := mw.writer.Write(chunk)
+		written += n
+		mw.meter.transferred(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Wraps r so that everything read from it is measured and throttled.
+func (m *transferMeter) reader(r io.Reader) io.Reader {
+	if m == nil {
+		return r
+	}
+	return &meteredReader{reader: r, meter: m}
+}
+
+type meteredReader struct {
+	reader io.Reader
+	meter  *transferMeter
+}
+
+func (mr *meteredReader) Read(p []byte) (int, error) {
+	if limit := int(mr.meter.bandwidthLimit / 10); limit > 0 && len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := mr.reader.Read(p)
+	mr.meter.transferred(n)
+	return n, err
+}
+
+// Collects the progress of every host and reports the aggregate.
+type progressAggregator struct {
+	mutex    sync.Mutex
+	progress MultipleHostsProgressCallback
+	hosts    map[int]TransferProgress
+}
+
+func newProgressAggregator(progress MultipleHostsProgressCallback) *progressAggregator {
+	if progress == nil {
+		return nil
+	}
+	return &progressAggregator{
+		progress: progress,
+		hosts:    make(map[int]TransferProgress),
+	}
+}
+
+// Returns a ProgressCallback for the host with the given index which also calls the host's own callback.
+func (pa *progressAggregator) callbackFor(index int, client *SshClient) ProgressCallback {
+	if pa == nil {
+		return client.ProgressCallback
+	}
+	hostCallback := client.ProgressCallback
+	return func(progress TransferProgress) {
+		if hostCallback != nil {
+			hostCallback(progress)
+		}
+		pa.mutex.Lock()
+		defer pa.mutex.Unlock()
+		pa.hosts[index] = progress
+		aggregated := MultipleHostsProgress{
+			Hosts: make(map[int]TransferProgress, len(pa.hosts)),
+		}
+		for hostIndex, hostProgress := range pa.hosts {
+			aggregated.Hosts[hostIndex] = hostProgress
+			aggregated.BytesTransferred += hostProgress.BytesTransferred
+			aggregated.TotalBytes += hostProgress.TotalBytes
+		}
+		pa.progress(aggregated)
+	}
+}
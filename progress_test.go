@@ -0,0 +1,84 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type chunkRecorder struct {
+	chunks []int
+}
+
+func (cr *chunkRecorder) Write(p []byte) (int, error) {
+	cr.chunks = append(cr.chunks, len(p))
+	return len(p), nil
+}
+
+func TestTransferMeterReports(t *testing.T) {
+	var reports []TransferProgress
+	meter := (&SshClient{Address: "web1"}).newTransferMeter(func(progress TransferProgress) {
+		reports = append(reports, progress)
+	})
+	meter.startTime = time.Now().Add(-2 * time.Second)
+	meter.addTotal(400)
+	meter.startFile("release.tar")
+	assert.Equal(t, 1, len(reports), "starting a file should always be reported")
+	meter.transferred(100)
+	assert.Equal(t, 1, len(reports), "the reports should be throttled to progressReportInterval")
+
+	meter.lastReport = time.Now().Add(-progressReportInterval)
+	meter.transferred(100)
+	if assert.Equal(t, 2, len(reports)) {
+		last := reports[1]
+		assert.Equal(t, "web1", last.Address)
+		assert.Equal(t, "release.tar", last.File)
+		assert.Equal(t, int64(200), last.BytesTransferred)
+		assert.InDelta(t, 100, last.Rate, 5, "the rate should be the bytes per second since the start")
+		assert.InDelta(t, float64(2*time.Second), float64(last.ETA), float64(200*time.Millisecond),
+			"the ETA should be the remaining bytes at the current rate")
+	}
+
+	meter.transferred(200)
+	meter.finishFile()
+	assert.Equal(t, 3, len(reports), "finishing a file should always be reported")
+	assert.Equal(t, time.Duration(0), reports[2].ETA, "there should be no ETA once everything is transferred")
+}
+
+func TestTransferMeterBandwidthLimit(t *testing.T) {
+	assert.Nil(t, (&SshClient{}).newTransferMeter(nil), "no meter is needed without a callback and a limit")
+	meter := (&SshClient{BandwidthLimit: 1000}).newTransferMeter(nil)
+	recorder := &chunkRecorder{}
+	start := time.Now()
+	n, err := meter.writer(recorder).Write(make([]byte, 250))
+	assert.Nil(t, err)
+	assert.Equal(t, 250, n)
+	assert.Equal(t, []int{100, 100, 50}, recorder.chunks, "the writes should be split into a tenth of the limit")
+	assert.True(t, time.Since(start) >= 250*time.Millisecond, "250 bytes should take at least 250ms at 1000 B/s")
+}
+
+func TestProgressAggregator(t *testing.T) {
+	assert.Nil(t, newProgressAggregator(nil).callbackFor(0, &SshClient{}),
+		"without an aggregated callback only the callback of the host is used")
+	var aggregated MultipleHostsProgress
+	aggregator := newProgressAggregator(func(progress MultipleHostsProgress) {
+		aggregated = progress
+	})
+	var hostReports int
+	web1 := aggregator.callbackFor(0, &SshClient{ProgressCallback: func(progress TransferProgress) {
+		hostReports++
+	}})
+	web2 := aggregator.callbackFor(1, &SshClient{})
+	// the same address through another bastion
+	web2Bastion := aggregator.callbackFor(2, &SshClient{})
+	web1(TransferProgress{Address: "web1", BytesTransferred: 10, TotalBytes: 100})
+	web2(TransferProgress{Address: "web2", BytesTransferred: 30, TotalBytes: 200})
+	web1(TransferProgress{Address: "web1", BytesTransferred: 50, TotalBytes: 100})
+	web2Bastion(TransferProgress{Address: "web2", BytesTransferred: 5, TotalBytes: 200})
+	assert.Equal(t, 2, hostReports, "the callback of the host should still be invoked")
+	assert.Equal(t, int64(85), aggregated.BytesTransferred, "the last progress of every host should be summed")
+	assert.Equal(t, int64(500), aggregated.TotalBytes)
+	assert.Equal(t, 3, len(aggregated.Hosts), "hosts with the same address should be kept apart")
+	assert.Equal(t, int64(50), aggregated.Hosts[0].BytesTransferred)
+	assert.Equal(t, int64(5), aggregated.Hosts[2].BytesTransferred)
+}
@@ -0,0 +1,165 @@
+package gosher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The mode of remote files which are created without a given mode.
+const defaultRemoteFileMode = 0644
+
+// Reads a remote file and its permissions, exists is false if there is no such file.
+// remotePath is quoted, so a leading ~ has to be expanded with expandRemotePath first.
+func (s *SshClient) readRemoteFile(remotePath string) (content []byte, mode os.FileMode, exists bool, err error) {
+	quotedPath := shellQuote(remotePath)
+	var output bytes.Buffer
+	// the first line is the mode (GNU or BSD stat), the rest is the content
+	command := fmt.Sprintf("if [ -f %s ]; then { stat -c %%a %s 2>/dev/null || stat -f %%Lp %s; } && cat %s; fi",
+		quotedPath, quotedPath, quotedPath, quotedPath)
+	if err = s.runInNewSession(command, nil, &output); err != nil {
+		return nil, 0, false, NewSshConnectionError("There was an error while reading " + remotePath + ": " + err.Error())
+	}
+	if output.Len() == 0 {
+		return nil, 0, false, nil
+	}
+	modeLine, err := output.ReadString('\n')
+	if err != nil {
+		return nil, 0, false, NewSshConnectionError("Unexpected output while reading " + remotePath)
+	}
+	parsedMode, err := parseRemoteMode(modeLine[:len(modeLine)-1])
+	if err != nil {
+		return nil, 0, false, NewSshConnectionError("Unexpected mode of " + remotePath + ": " + modeLine)
+	}
+	return output.Bytes(), parsedMode, true, nil
+}
+
+// The bits of an os.FileMode which are set on remote files.
+const remoteModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// Parses the octal mode printed by stat, its setuid, setgid and sticky bits
+// become os.ModeSetuid, os.ModeSetgid and os.ModeSticky.
+func parseRemoteMode(octalMode string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(octalMode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	mode := os.FileMode(bits).Perm()
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// Returns the octal mode chmod sets for mode, the reverse of parseRemoteMode.
+func chmodMode(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return strconv.FormatUint(uint64(bits), 8)
+}
+
+// Replaces a remote file atomically with content unless it already has the same content and mode.
+// A mode of 0 keeps the mode of an existing file, new files get defaultRemoteFileMode.
+// Nothing is written in CheckMode.
+// Returns the previous content and whether the file was changed.
+func (s *SshClient) updateRemoteFile(remotePath string, content []byte,
+	mode os.FileMode) (previous []byte, changed bool, err error) {
+	if remotePath, err = s.expandRemotePath(remotePath); err != nil {
+		return nil, false, err
+	}
+	previous, currentMode, exists, err := s.readRemoteFile(remotePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if mode == 0 {
+		mode = defaultRemoteFileMode
+		if exists {
+			mode = currentMode
+		}
+	}
+	if exists && bytes.Equal(previous, content) && currentMode == mode&remoteModeBits {
+		return previous, false, nil
+	}
+	if s.CheckMode {
+		return previous, true, nil
+	}
+	return previous, true, s.writeRemoteFile(remotePath, content, mode)
+}
+
+// Writes content to a temporary file next to remotePath and renames it over remotePath.
+// The owner and group of an existing file are kept if the user is allowed to set them.
+func (s *SshClient) writeRemoteFile(remotePath string, content []byte, mode os.FileMode) error {
+	// chown clears the setuid and setgid bits, so the mode is set after it
+	command := fmt.Sprintf(`tmp=$(mktemp %s) && { cat > "$tmp" && %s && chmod %s "$tmp" && mv -f "$tmp" %s || { rm -f "$tmp"; exit 1; }; }`,
+		shellQuote(path.Join(path.Dir(remotePath), ".gosher.XXXXXX")), copyOwnerCommand(remotePath, `"$tmp"`),
+		chmodMode(mode), shellQuote(remotePath))
+	if err := s.runInNewSession(command, bytes.NewReader(content), nil); err != nil {
+		return NewSshConnectionError("There was an error while writing " + remotePath + ": " + err.Error())
+	}
+	return nil
+}
+
+// Compares the local file/folder with the remote one without writing anything
+// and returns the Diff of every file which would be changed by the upload.
+func (s *SshClient) checkUpload(localPath string, remotePath string, isDir bool,
+	options TransferOptions) (*SshResponse, error) {
+	localPaths, remotePaths := []string{localPath}, []string{remotePath}
+	if isDir {
+		plan, err := planFolderUpload(localPath, options)
+		if err != nil {
+			return nil, err
+		}
+		localPaths, remotePaths = nil, nil
+		for _, entry := range plan.entries {
+			if entry.record == 'C' {
+				localPaths = append(localPaths, entry.localPath)
+				remotePaths = append(remotePaths, path.Join(remotePath, entry.relativePath))
+			}
+		}
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	var diff strings.Builder
+	for i, localFile := range localPaths {
+		newContent, err := ioutil.ReadFile(localFile)
+		if err != nil {
+			return response, err
+		}
+		content, _, exists, err := s.readRemoteFile(remotePaths[i])
+		if err != nil {
+			return response, err
+		}
+		if !exists || !bytes.Equal(content, newContent) {
+			response.Changed = true
+			diff.WriteString(contentDiff(remotePaths[i], content, newContent))
+		}
+	}
+	response.Diff = diff.String()
+	return response, nil
+}
+
+// Returns a command which gives the file target, a shell word, the owner and group of remotePath if it exists.
+// Only root can give a file away, so for other users it keeps their own and never fails.
+func copyOwnerCommand(remotePath string, target string) string {
+	quotedPath := shellQuote(remotePath)
+	return fmt.Sprintf(`{ [ ! -e %s ] || chown "$(stat -c %%u:%%g %s 2>/dev/null || stat -f %%u:%%g %s)" %s 2>/dev/null || true; }`,
+		quotedPath, quotedPath, quotedPath, target)
+}
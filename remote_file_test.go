@@ -0,0 +1,50 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRemoteMode(t *testing.T) {
+	mode, err := parseRemoteMode("4755")
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, mode, "the setuid bit should be parsed")
+	mode, err = parseRemoteMode("3770")
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSetgid|os.ModeSticky|0770, mode, "the setgid and sticky bits should be parsed")
+	assert.Equal(t, "3770", chmodMode(mode), "chmod should set the same bits")
+	assert.Equal(t, "644", chmodMode(0644))
+	_, err = parseRemoteMode("rwx")
+	assert.NotNil(t, err, "an invalid mode should fail")
+}
+
+func TestUploadTemplateOverSetuidFile(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	templatePath := filepath.Join(dir, "tool.sh")
+	assert.Nil(t, ioutil.WriteFile(templatePath, []byte("echo {{.Vars.name}}\n"), 0644))
+	_, runError := s.Run("echo 'echo tool' > setuidfile && chmod 4755 setuidfile")
+	assert.Nil(t, runError, "creating the test file returned an error")
+
+	vars := map[string]interface{}{"name": "tool"}
+	response, uploadError := s.UploadTemplate(templatePath, "setuidfile", vars, 0)
+	assert.Nil(t, uploadError, "UploadTemplate returned an error")
+	assert.False(t, response.Changed, "a setuid file with the same content shouldn't be changed")
+	response, uploadError = s.UploadTemplate(templatePath, "setuidfile", vars, os.ModeSetuid|0755)
+	assert.Nil(t, uploadError, "UploadTemplate returned an error")
+	assert.False(t, response.Changed, "a setuid file with the same content and mode shouldn't be changed")
+
+	vars["name"] = "changed"
+	response, uploadError = s.UploadTemplate(templatePath, "setuidfile", vars, 0)
+	assert.Nil(t, uploadError, "UploadTemplate returned an error")
+	assert.True(t, response.Changed, "a file with a different content should be changed")
+	statResponse, statError := s.Run("stat -c %a setuidfile")
+	assert.Nil(t, statError, "reading the mode of the test file returned an error")
+	assert.Equal(t, "4755\n", statResponse.StdOut.String(), "the setuid bit of the file should be kept")
+}
@@ -0,0 +1,142 @@
+package gosher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Uploads a single file continuing from the part of it that is already on the remote machine.
+// remotePath is quoted, so a leading ~ has to be expanded first, see uploadWithProgress.
+func (s *SshClient) uploadResumable(localPath string, remotePath string, meter *transferMeter) (*SshResponse, error) {
+	localInfo, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	remoteSize, exists := s.remoteFileSize(remotePath)
+	if !exists {
+		return s.uploadFile(localPath, remotePath, meter)
+	}
+	offset, err := s.resumeOffset(localPath, remotePath, remoteSize, localInfo.Size())
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 {
+		return s.uploadFile(localPath, remotePath, meter)
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	if offset == localInfo.Size() {
+		return response, nil
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return response, err
+	}
+	defer file.Close()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return response, err
+	}
+	meter.addTotal(localInfo.Size() - offset)
+	meter.startFile(localPath)
+	appendCommand := "cat >> " + shellQuote(remotePath)
+	if err = s.runInNewSession(appendCommand, meter.reader(file), &response.StdOut); err != nil {
+		return response, NewSshConnectionError("There was an error while uploading: " + err.Error())
+	}
+	meter.finishFile()
+	return response, nil
+}
+
+// Downloads a single file continuing from the part of it that is already on the local machine.
+func (s *SshClient) downloadResumable(remotePath string, localPath string, meter *transferMeter,
+	options TransferOptions) (*SshResponse, error) {
+	remoteSize, exists := s.remoteFileSize(remotePath)
+	if !exists {
+		// folder or missing file, the regular download handles both
+		return s.download(remotePath, localPath, meter, options)
+	}
+	localFilePath := localPath
+	localInfo, err := os.Stat(localPath)
+	if err == nil && localInfo.IsDir() {
+		localFilePath = filepath.Join(localPath, filepath.Base(remotePath))
+		localInfo, err = os.Stat(localFilePath)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s.download(remotePath, localPath, meter, options)
+		}
+		return nil, err
+	}
+	offset, err := s.resumeOffset(localFilePath, remotePath, localInfo.Size(), remoteSize)
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 {
+		return s.download(remotePath, localPath, meter, options)
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	if offset == remoteSize {
+		return response, nil
+	}
+	file, err := os.OpenFile(localFilePath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return response, err
+	}
+	defer file.Close()
+	meter.addTotal(remoteSize - offset)
+	meter.startFile(localFilePath)
+	tailCommand := fmt.Sprintf("tail -c +%d %s", offset+1, shellQuote(remotePath))
+	if err = s.runInNewSession(tailCommand, nil, meter.writer(file)); err != nil {
+		return response, NewSshConnectionError("There was an error while downloading: " + err.Error())
+	}
+	meter.finishFile()
+	return response, file.Close()
+}
+
+// Returns the number of bytes which don't have to be transferred again:
+// the size of the partial destination if it matches the beginning of the source, 0 otherwise.
+func (s *SshClient) resumeOffset(localPath string, remotePath string,
+	partialSize int64, sourceSize int64) (int64, error) {
+	return matchingPrefixSize(localPath, partialSize, sourceSize, func(length int64) (string, error) {
+		return s.remoteChecksum(remotePath, length)
+	})
+}
+
+// Returns partialSize if the checksum of the first partialSize bytes of the local file is the same
+// as the one of the remote file returned by remotePrefixChecksum, 0 if it isn't
+// or if partialSize is larger than sourceSize.
+func matchingPrefixSize(localPath string, partialSize int64, sourceSize int64,
+	remotePrefixChecksum func(length int64) (string, error)) (int64, error) {
+	if partialSize == 0 || partialSize > sourceSize {
+		return 0, nil
+	}
+	localChecksum, err := localChecksum(localPath, partialSize)
+	if err != nil {
+		return 0, err
+	}
+	remoteChecksum, err := remotePrefixChecksum(partialSize)
+	if err != nil {
+		return 0, err
+	}
+	if localChecksum != remoteChecksum {
+		return 0, nil
+	}
+	return partialSize, nil
+}
+
+// Returns the size of a remote regular file and false if it doesn't exist or isn't a file.
+func (s *SshClient) remoteFileSize(remotePath string) (int64, bool) {
+	quotedPath := shellQuote(remotePath)
+	sizeOutput, err := s.output(fmt.Sprintf("test -f %s && wc -c < %s", quotedPath, quotedPath))
+	if err != nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(sizeOutput, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,86 @@
+package gosher
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchingPrefixSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	localPath := filepath.Join(dir, "release.tar")
+	assert.Nil(t, ioutil.WriteFile(localPath, []byte("release"), 0644))
+	// the remote file starts with "rel" followed by something else
+	remote := []byte("relapse")
+	remoteChecksum := func(length int64) (string, error) {
+		return checksumOf(t, dir, remote[:length]), nil
+	}
+
+	cases := []struct {
+		name        string
+		partialSize int64
+		sourceSize  int64
+		expected    int64
+	}{
+		{"matching prefix", 3, 7, 3},
+		{"equal sizes", 7, 7, 0},
+		{"partial larger than the source", 8, 7, 0},
+		{"empty partial", 0, 7, 0},
+	}
+	for _, c := range cases {
+		offset, err := matchingPrefixSize(localPath, c.partialSize, c.sourceSize, remoteChecksum)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.expected, offset, c.name)
+	}
+
+	remote = []byte("release")
+	offset, err := matchingPrefixSize(localPath, 7, 7, remoteChecksum)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), offset, "an identical file shouldn't be transferred again")
+
+	remote = []byte("xelease")
+	offset, err = matchingPrefixSize(localPath, 3, 7, remoteChecksum)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), offset, "the transfer should start over when the prefix doesn't match")
+
+	_, err = matchingPrefixSize(filepath.Join(dir, "missing"), 3, 7, remoteChecksum)
+	assert.NotNil(t, err, "a missing local file should fail")
+
+	_, err = matchingPrefixSize(localPath, 3, 7, func(length int64) (string, error) {
+		return "", errors.New("connection lost")
+	})
+	assert.NotNil(t, err, "the error of the remote checksum should be returned")
+}
+
+// Returns the checksum of content the same way it is computed for a local file.
+func checksumOf(t *testing.T, dir string, content []byte) string {
+	contentPath := filepath.Join(dir, "content")
+	assert.Nil(t, ioutil.WriteFile(contentPath, content, 0644))
+	checksum, err := localChecksum(contentPath, -1)
+	assert.Nil(t, err)
+	return checksum
+}
+
+func TestUploadResumableToHome(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("printf rel > ~/resumefile")
+	assert.Nil(t, runError, "creating the partial file returned an error")
+	ioutil.WriteFile("resumefile", []byte("release"), 0644)
+	defer os.Remove("resumefile")
+	var transferred int64
+	s.ProgressCallback = func(progress TransferProgress) {
+		transferred = progress.BytesTransferred
+	}
+	_, uploadError := s.UploadWithOptions("resumefile", "~/resumefile", TransferOptions{Resume: true})
+	assert.Nil(t, uploadError, "UploadWithOptions returned an error")
+	assert.Equal(t, int64(4), transferred, "only the rest of the partial file in the home directory should be sent")
+	catResponse, catError := s.Run("cat ~/resumefile")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "release", catResponse.StdOut.String())
+}
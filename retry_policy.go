@@ -0,0 +1,176 @@
+package gosher
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// RetryPolicy - how an operation is retried after it fails.
+// MaxAttempts - the number of times the operation is attempted including the first one, 1 if it is less.
+// InitialBackoff - the delay before the first retry, multiplied by Multiplier before every next one.
+// Multiplier - 2 if it is less than 1.
+// MaxBackoff - 0 (unlimited) by default, the longest delay between two attempts.
+// Jitter - 0 by default, the part of every delay which is random, e.g. 0.2 makes it between 80% and 120%
+// of the delay, so that many hosts don't retry at the same time.
+// RetryExitCodes - none by default, the exit codes of failed commands which are retried.
+// Retryable - nil by default, if set it decides which failures are retried instead of
+// IsConnectionFailure and RetryExitCodes, e.g. IsConnectionLost for commands which are safe to repeat.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	Multiplier     float64
+	MaxBackoff     time.Duration
+	Jitter         float64
+	RetryExitCodes []int
+	Retryable      func(response *SshResponse, err error) bool
+}
+
+// Constructor method for RetryPolicy.
+// Returns a policy which retries connection failures up to maxAttempts attempts
+// with backoff from 1 second up to 30 seconds and 20% jitter.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: time.Second,
+		Multiplier:     2,
+		MaxBackoff:     30 * time.Second,
+		Jitter:         0.2,
+	}
+}
+
+// Reports whether err is a connection failure: the host couldn't be reached, the connection broke
+// during the handshake or a session couldn't be opened, so nothing ran on the remote machine.
+// Failed commands, rejected authentication or host keys, cancelled operations and connections
+// lost while a command was running aren't, see IsConnectionLost.
+func IsConnectionFailure(err error) bool {
+	var setupErr *setupError
+	return errors.As(err, &setupErr) && IsConnectionLost(setupErr.err)
+}
+
+// Reports whether err is a connection failure or the connection was lost while an operation was
+// running, e.g. the host rebooted during a command, which may have run anyway.
+// It can be used as RetryPolicy.Retryable for operations which are safe to repeat.
+func IsConnectionLost(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	var exitMissingErr *ssh.ExitMissingError
+	var openChannelErr *ssh.OpenChannelError
+	return errors.As(err, &netErr) || errors.As(err, &exitMissingErr) || errors.As(err, &openChannelErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// setupError - a failure to connect to the remote machine or to open a session,
+// before anything ran on it.
+type setupError struct {
+	err error
+}
+
+func (se *setupError) Error() string {
+	return se.err.Error()
+}
+
+func (se *setupError) Unwrap() error {
+	return se.err
+}
+
+// Reports whether the failure of an attempt is retried.
+func (rp *RetryPolicy) retryable(response *SshResponse, err error) bool {
+	if err == nil {
+		return false
+	}
+	if rp.Retryable != nil {
+		return rp.Retryable(response, err)
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		for _, code := range rp.RetryExitCodes {
+			if code == exitErr.ExitStatus() {
+				return true
+			}
+		}
+		return false
+	}
+	return IsConnectionFailure(err)
+}
+
+// Returns the delay before the given retry, the first one is 1.
+func (rp *RetryPolicy) backoff(retry int) time.Duration {
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(rp.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if rp.MaxBackoff > 0 && delay > float64(rp.MaxBackoff) {
+		delay = float64(rp.MaxBackoff)
+	}
+	if rp.Jitter > 0 {
+		delay *= 1 + rp.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Executes operation until it succeeds, fails with a failure which isn't retried or runs out of attempts.
+// The backoff between the attempts is interrupted when ctx, which can be nil, is done.
+// beforeRetry, which can be nil, is called before every retry.
+// Returns the result of the last attempt and the number of attempts, a nil policy attempts the operation once.
+func (rp *RetryPolicy) run(ctx context.Context, operation func() (*SshResponse, error),
+	beforeRetry func()) (*SshResponse, int, error) {
+	response, err := operation()
+	if rp == nil {
+		return response, 1, err
+	}
+	attempts := 1
+	for ; attempts < rp.MaxAttempts && rp.retryable(response, err); attempts++ {
+		timer := time.NewTimer(rp.backoff(attempts))
+		if ctx != nil {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return response, attempts, err
+			}
+		} else {
+			<-timer.C
+		}
+		if beforeRetry != nil {
+			beforeRetry()
+		}
+		response, err = operation()
+	}
+	return response, attempts, err
+}
+
+// Executes operation with the client and retries it by the RetryPolicy of the client,
+// e.g. to retry an Upload, the operations of the client in it aren't retried on their own.
+// Before a retry the session of a client with StickySession is closed, so it is opened again.
+// Returns the result of the last attempt.
+func (s *SshClient) Retry(operation func(client *SshClient) (*SshResponse, error)) (*SshResponse, error) {
+	return s.retry(func() (*SshResponse, error) {
+		return operation(s)
+	})
+}
+
+func (s *SshClient) retry(operation func() (*SshResponse, error)) (*SshResponse, error) {
+	if s.retrying {
+		return operation()
+	}
+	s.retrying = true
+	defer func() { s.retrying = false }()
+	response, _, err := s.RetryPolicy.run(s.context, operation, s.resetSession)
+	return response, err
+}
+
+// Closes the session if it is still open, so the next operation opens a new one.
+func (s *SshClient) resetSession() {
+	if s.isSessionOpened {
+		s.CloseSession()
+	}
+}
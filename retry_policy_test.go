@@ -0,0 +1,99 @@
+package gosher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 4*time.Second, policy.backoff(3), "the backoff should double by default")
+	assert.Equal(t, 5*time.Second, policy.backoff(10), "the backoff should be capped by MaxBackoff")
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := policy.backoff(2)
+		assert.True(t, backoff >= time.Second && backoff <= 3*time.Second, backoff)
+	}
+}
+
+func TestIsConnectionFailure(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	assert.True(t, IsConnectionFailure(wrapSshConnectionError("There was an error while creating a client: ",
+		&setupError{dialErr})))
+	assert.True(t, IsConnectionFailure(&setupError{&handshakeError{errors.New("ssh: handshake failed: EOF"), io.EOF}}))
+	assert.True(t, IsConnectionFailure(&setupError{fmt.Errorf("bastion jump: %w", dialErr)}))
+
+	assert.False(t, IsConnectionFailure(nil))
+	assert.False(t, IsConnectionFailure(&setupError{&handshakeError{errors.New("ssh: unable to authenticate"), nil}}),
+		"a rejected authentication shouldn't be retried")
+	assert.False(t, IsConnectionFailure(NewSshConnectionError("There was an error while executing the command")))
+	lost := wrapSshConnectionError("There was an error while executing the command: ", &ssh.ExitMissingError{})
+	assert.False(t, IsConnectionFailure(lost), "a command which may have run shouldn't be retried by default")
+	assert.True(t, IsConnectionLost(lost))
+	assert.False(t, IsConnectionLost(context.Canceled))
+}
+
+func TestRetryPolicyRun(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	refused := &setupError{&net.OpError{Op: "dial", Err: errors.New("connection refused")}}
+	calls := 0
+	_, attempts, err := policy.run(nil, func() (*SshResponse, error) {
+		calls++
+		if calls < 2 {
+			return nil, refused
+		}
+		return &SshResponse{}, nil
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	_, attempts, err = policy.run(nil, func() (*SshResponse, error) {
+		return nil, refused
+	}, nil)
+	assert.Equal(t, refused, err)
+	assert.Equal(t, 3, attempts, "the operation should be attempted MaxAttempts times")
+
+	_, attempts, _ = policy.run(nil, func() (*SshResponse, error) {
+		return nil, errors.New("no such file")
+	}, nil)
+	assert.Equal(t, 1, attempts, "failures which aren't connection failures shouldn't be retried")
+
+	policy.Retryable = func(response *SshResponse, err error) bool {
+		return response != nil && response.ExitCode == 75
+	}
+	_, attempts, _ = policy.run(nil, func() (*SshResponse, error) {
+		return &SshResponse{ExitCode: 75}, errors.New("exit status 75")
+	}, nil)
+	assert.Equal(t, 3, attempts)
+
+	var nilPolicy *RetryPolicy
+	_, attempts, _ = nilPolicy.run(nil, func() (*SshResponse, error) {
+		return nil, refused
+	}, nil)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestMultipleHostsRetry(t *testing.T) {
+	msc := newTestHosts("web-1", "web-2")
+	msc.RetryPolicy = &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	calls := make([]int, 2)
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		calls[index]++
+		if index == 1 && calls[index] < 3 {
+			return nil, &setupError{io.EOF}
+		}
+		return &SshResponse{}, nil
+	})
+	assert.Equal(t, 1, results[0].Attempts)
+	assert.Equal(t, 3, results[1].Attempts)
+	assert.Nil(t, results[1].Err)
+}
@@ -0,0 +1,252 @@
+package gosher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Splits the hosts into the batches of the Serial rollout, all hosts are a single batch if Serial isn't set.
+// The Canary hosts are a batch of their own before the others.
+func (msc *MultipleHostsSshClient) batches() ([][]int, error) {
+	batchSize := len(msc.Hosts)
+	if msc.Serial != "" {
+		var err error
+		if batchSize, err = parseSerial(msc.Serial, len(msc.Hosts)); err != nil {
+			return nil, err
+		}
+	}
+	var batches [][]int
+	first := 0
+	if msc.Canary != "" {
+		canaries, err := parseHostCount(msc.Canary, len(msc.Hosts))
+		if err != nil {
+			return nil, errors.New("Invalid Canary: " + msc.Canary)
+		}
+		if canaries > len(msc.Hosts) {
+			canaries = len(msc.Hosts)
+		}
+		batches = append(batches, hostRange(0, canaries))
+		first = canaries
+	}
+	for start := first; start < len(msc.Hosts); start += batchSize {
+		end := start + batchSize
+		if end > len(msc.Hosts) {
+			end = len(msc.Hosts)
+		}
+		batches = append(batches, hostRange(start, end))
+	}
+	return batches, nil
+}
+
+// Returns the indexes of the hosts from start up to end.
+func hostRange(start int, end int) []int {
+	indexes := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+// Returns the number of hosts in a batch of a Serial rollout, serial is either a number of hosts
+// or a percentage of hostCount like "25%", rounded up to at least one host.
+func parseSerial(serial string, hostCount int) (int, error) {
+	batchSize, err := parseHostCount(serial, hostCount)
+	if err != nil {
+		return 0, errors.New("Invalid Serial: " + serial)
+	}
+	return batchSize, nil
+}
+
+// Parses a number of hosts or a percentage of hostCount like "25%", rounded up to at least one host.
+func parseHostCount(count string, hostCount int) (int, error) {
+	value := strings.TrimSpace(count)
+	isPercentage := strings.HasSuffix(value, "%")
+	number, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || number <= 0 || isPercentage && number > 100 {
+		return 0, errors.New("Invalid number of hosts: " + count)
+	}
+	if !isPercentage {
+		return number, nil
+	}
+	batchSize := (number*hostCount + 99) / 100
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return batchSize, nil
+}
+
+// Reports whether more hosts of a batch failed than MaxFailPercentage allows.
+func (msc *MultipleHostsSshClient) exceedsMaxFailures(failures int, batchSize int) bool {
+	return failures*100 > msc.MaxFailPercentage*batchSize
+}
+
+// Returns the error the hosts after the batch with the given index are skipped with,
+// nil if the rollout goes on.
+func (msc *MultipleHostsSshClient) abortReason(batchIndex int, batchResults []HostResult) error {
+	// the canaries are only decided by canarySucceeded, an error accepted by CanaryCheck doesn't count
+	if batchIndex == 0 && msc.Canary != "" {
+		for _, result := range batchResults {
+			if !msc.canarySucceeded(result) {
+				return NewHostSkippedError("the canary " + result.Address + " failed")
+			}
+		}
+		return nil
+	}
+	failures := 0
+	for _, result := range batchResults {
+		if result.Err != nil && !result.Skipped() {
+			failures++
+		}
+	}
+	if msc.exceedsMaxFailures(failures, len(batchResults)) {
+		return NewHostSkippedError("the rollout was aborted after " + strconv.Itoa(failures) +
+			" of " + strconv.Itoa(len(batchResults)) + " hosts of a batch failed")
+	}
+	return nil
+}
+
+func (msc *MultipleHostsSshClient) canarySucceeded(result HostResult) bool {
+	if msc.CanaryCheck != nil {
+		return msc.CanaryCheck(result)
+	}
+	return result.Err == nil
+}
+
+// Returns the function which is called with the result of every host which ran the operation
+// before another host can start, it cancels the operation with FailFast and once the Quorum
+// is reached or can't be reached anymore.
+func (msc *MultipleHostsSshClient) resultHandler(cancel context.CancelCauseFunc) func(result HostResult) {
+	var mutex sync.Mutex
+	successes, failures := 0, 0
+	quorum := strconv.Itoa(msc.Quorum)
+	return func(result HostResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if result.Skipped() {
+			return
+		}
+		if result.Err == nil {
+			successes++
+		} else {
+			failures++
+		}
+		switch {
+		case result.Err != nil && msc.FailFast:
+			cancel(errors.New(result.Address + " failed"))
+		case msc.Quorum > 0 && successes >= msc.Quorum:
+			// the hosts which are still running are skipped, the operation isn't needed on them
+			cancel(NewHostSkippedError("the quorum of " + quorum + " hosts was reached"))
+		case msc.Quorum > 0 && failures > len(msc.Hosts)-msc.Quorum:
+			cancel(errors.New("the quorum of " + quorum + " hosts can't be reached"))
+		}
+	}
+}
+
+// Reports whether the operation succeeded on at least Quorum hosts.
+func (msc *MultipleHostsSshClient) QuorumReached(results []HostResult) bool {
+	successes := 0
+	for _, result := range results {
+		if result.Err == nil {
+			successes++
+		}
+	}
+	return successes >= msc.Quorum
+}
+
+// Returns the context of an operation on the hosts, which is cancelled with the error of the first
+// failed host with FailFast or once the Quorum is decided. It is nil if the operation can't be cancelled.
+func (msc *MultipleHostsSshClient) executionContext() (context.Context, context.CancelCauseFunc) {
+	if msc.context == nil && !msc.FailFast && msc.Quorum <= 0 {
+		return nil, func(error) {}
+	}
+	parent := msc.context
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithCancelCause(parent)
+}
+
+// Executes task on the hosts of the batch, at most Parallelism of them at the same time,
+// and sends their results. onResult is called with the result of every host before its slot is freed.
+// The hosts which haven't started when ctx is done are skipped.
+// Returns the results of the hosts of the batch.
+func (msc *MultipleHostsSshClient) runBatch(batch []int, task hostTask, ctx context.Context,
+	onResult func(result HostResult), results chan<- HostResult) []HostResult {
+	var slots chan struct{}
+	if msc.Parallelism > 0 {
+		slots = make(chan struct{}, msc.Parallelism)
+	}
+	var wait sync.WaitGroup
+	var mutex sync.Mutex
+	var batchResults []HostResult
+	send := func(result HostResult) {
+		mutex.Lock()
+		batchResults = append(batchResults, result)
+		mutex.Unlock()
+		results <- result
+	}
+	for _, index := range batch {
+		if !acquireSlot(ctx, slots) {
+			send(msc.skippedResult(index, skipError(ctx)))
+			continue
+		}
+		wait.Add(1)
+		go func(index int) {
+			defer wait.Done()
+			result := msc.runTask(index, task, ctx)
+			// before the slot is freed, so no other host starts if the operation is cancelled
+			onResult(result)
+			if slots != nil {
+				<-slots
+			}
+			send(result)
+		}(index)
+	}
+	wait.Wait()
+	return batchResults
+}
+
+// Waits for a free slot, there is no limit if slots is nil.
+// Returns false if ctx is done before a host can start.
+func acquireSlot(ctx context.Context, slots chan struct{}) bool {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-done:
+			return false
+		}
+	}
+	if ctx != nil && ctx.Err() != nil {
+		if slots != nil {
+			<-slots
+		}
+		return false
+	}
+	return true
+}
+
+// Returns the error of the hosts which are skipped because ctx is done.
+func skipError(ctx context.Context) *HostSkippedError {
+	if skipErr, ok := context.Cause(ctx).(*HostSkippedError); ok {
+		return skipErr
+	}
+	return NewHostSkippedError(context.Cause(ctx).Error())
+}
+
+// Returns the result of a host on which the operation wasn't started.
+func (msc *MultipleHostsSshClient) skippedResult(index int, err error) HostResult {
+	host := msc.Hosts[index]
+	return HostResult{
+		Index:   index,
+		Host:    host,
+		Address: host.Client.Address,
+		Err:     err,
+	}
+}
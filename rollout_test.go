@@ -0,0 +1,197 @@
+package gosher
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSerial(t *testing.T) {
+	for serial, expected := range map[string]int{"2": 2, "25%": 3, "1%": 1, "100%": 10} {
+		batchSize, err := parseSerial(serial, 10)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, batchSize, serial)
+	}
+	for _, serial := range []string{"0", "-1", "150%", "a", "%"} {
+		_, err := parseSerial(serial, 10)
+		assert.NotNil(t, err, serial)
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d", "e")
+	msc.Parallelism = 2
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return &SshResponse{}, nil
+	})
+	assert.Equal(t, 5, len(results))
+	assert.Equal(t, 2, maxRunning)
+}
+
+func TestSerialRolloutAborts(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d", "e")
+	msc.Serial = "2"
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index == 2 {
+			return nil, errors.New("restart failed")
+		}
+		return &SshResponse{}, nil
+	})
+	assert.Nil(t, results[0].Err)
+	assert.False(t, results[2].Skipped(), "the failed host isn't skipped")
+	assert.Nil(t, results[3].Err, "the rest of the failed batch finishes")
+	assert.True(t, results[4].Skipped(), "the next batches should be skipped")
+
+	msc.MaxFailPercentage = 50
+	results = msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index == 2 {
+			return nil, errors.New("restart failed")
+		}
+		return &SshResponse{}, nil
+	})
+	assert.Nil(t, results[4].Err, "a failure within MaxFailPercentage shouldn't abort")
+}
+
+func TestFailFast(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d")
+	msc.FailFast = true
+	msc.Parallelism = 2
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index == 0 {
+			return nil, errors.New("deploy failed")
+		}
+		// the running operations are cancelled through the context of their client
+		<-client.context.Done()
+		return nil, client.context.Err()
+	})
+	succeeded, failed, skipped := SplitResults(results)
+	assert.Equal(t, 0, len(succeeded))
+	assert.Equal(t, 2, len(failed), "the failed and the cancelled host")
+	assert.Equal(t, 2, len(skipped), "the hosts which haven't started")
+	assert.Contains(t, results[3].Err.Error(), "a failed")
+}
+
+func TestWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	msc := newTestHosts("a", "b").WithContext(ctx)
+	started := false
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		started = true
+		return &SshResponse{}, nil
+	})
+	assert.False(t, started, "nothing should start after the context is done")
+	assert.True(t, results[0].Skipped())
+	assert.True(t, results[1].Skipped())
+}
+
+func TestCanary(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d", "e")
+	msc.Canary = "20%"
+	msc.Serial = "2"
+	batches, err := msc.batches()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]int{{0}, {1, 2}, {3, 4}}, batches, "the canary should be a batch of its own")
+
+	msc.CanaryCheck = func(result HostResult) bool {
+		return result.Response.StdOut.String() == "ok\n"
+	}
+	var mutex sync.Mutex
+	ran := 0
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		mutex.Lock()
+		ran++
+		mutex.Unlock()
+		response := &SshResponse{}
+		response.StdOut.WriteString("degraded\n")
+		return response, nil
+	})
+	assert.Equal(t, 1, ran, "only the canary should run")
+	assert.Nil(t, results[0].Err)
+	assert.True(t, results[1].Skipped())
+	assert.Contains(t, results[4].Err.Error(), "the canary a failed")
+
+	// grep exits with 1 when nothing matches, which the check accepts
+	msc.CanaryCheck = func(result HostResult) bool {
+		return result.Response != nil && result.Response.ExitCode == 1
+	}
+	results = msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index == 0 {
+			return &SshResponse{ExitCode: 1}, errors.New("Process exited with status 1")
+		}
+		return &SshResponse{ExitCode: 1}, nil
+	})
+	assert.NotNil(t, results[0].Err)
+	assert.False(t, results[1].Skipped(), "an error accepted by CanaryCheck shouldn't abort the rollout")
+	assert.False(t, results[4].Skipped(), "an error accepted by CanaryCheck shouldn't abort the rollout")
+
+	msc.Canary = "x"
+	_, err = msc.batches()
+	assert.NotNil(t, err)
+}
+
+func TestQuorum(t *testing.T) {
+	msc := newTestHosts("a", "b", "c", "d", "e")
+	msc.Quorum = 2
+	msc.Parallelism = 2
+	started := make(chan struct{})
+	results := msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		switch index {
+		case 0:
+			return &SshResponse{}, nil
+		case 1:
+			// the second success comes after the third host has started
+			<-started
+			return &SshResponse{}, nil
+		}
+		close(started)
+		<-client.context.Done()
+		return nil, client.context.Err()
+	})
+	succeeded, failed, skipped := SplitResults(results)
+	assert.Equal(t, 2, len(succeeded))
+	assert.Empty(t, failed)
+	assert.Equal(t, 3, len(skipped), "the host which was running should be cancelled and skipped")
+	assert.Contains(t, results[2].Err.Error(), "the quorum of 2 hosts was reached")
+	assert.Contains(t, results[4].Err.Error(), "the quorum of 2 hosts was reached")
+	assert.True(t, msc.QuorumReached(results))
+
+	msc.Serial = "3"
+	msc.Parallelism = 0
+	results = msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index < 2 {
+			return &SshResponse{}, nil
+		}
+		<-client.context.Done()
+		return nil, client.context.Err()
+	})
+	assert.True(t, results[2].Skipped(), "a cancelled host shouldn't count against MaxFailPercentage")
+	assert.Contains(t, results[4].Err.Error(), "the quorum of 2 hosts was reached")
+	msc.Serial = ""
+
+	msc.Quorum = 4
+	msc.Parallelism = 1
+	results = msc.collect(func(index int, client *SshClient) (*SshResponse, error) {
+		if index < 2 {
+			return nil, errors.New("down")
+		}
+		return &SshResponse{}, nil
+	})
+	assert.Contains(t, results[2].Err.Error(), "can't be reached", "the quorum can't be reached after 2 failures")
+	assert.False(t, msc.QuorumReached(results))
+}
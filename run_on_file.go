@@ -0,0 +1,206 @@
+package gosher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Executes a function on the contents of a remote file, which can be binary.
+// transformFunction gets the content of the file and returns the new content,
+// if it returns an error the file is left untouched.
+// The file is replaced atomically keeping its mode and only if the content changed,
+// its owner and group are kept only if the user is allowed to set them, e.g. as root.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) RunOnFileBytes(filePath string,
+	transformFunction func(content []byte) ([]byte, error)) (*SshResponse, error) {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	return s.runOnFileBytes(filePath, EditOptions{}, transformFunction)
+}
+
+// Executes transformFunction on the content of the remote file, a missing file is empty with options.Create.
+func (s *SshClient) runOnFileBytes(filePath string, options EditOptions,
+	transformFunction func(content []byte) ([]byte, error)) (*SshResponse, error) {
+	filePath, err := s.expandRemotePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	content, mode, exists, err := s.readRemoteFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && !options.Create {
+		return nil, NewSshConnectionError("There is no such file: " + filePath)
+	}
+	if !exists {
+		mode = options.Mode
+		if mode == 0 {
+			mode = defaultRemoteFileMode
+		}
+	}
+	newContent, err := transformFunction(content)
+	if err != nil {
+		return nil, err
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	if bytes.Equal(content, newContent) {
+		return response, nil
+	}
+	response.Changed = true
+	if s.CheckMode || options.Diff {
+		response.Diff = contentDiff(filePath, content, newContent)
+	}
+	if s.CheckMode {
+		return response, nil
+	}
+	return response, s.writeRemoteFile(filePath, newContent, mode)
+}
+
+// Executes a function on a remote file streaming its contents, so it can be used on huge files.
+// transformFunction reads the content of the file from reader and writes the new content to writer,
+// if it returns an error the file is left untouched.
+// The file is replaced atomically keeping its mode and only if the content changed,
+// its owner and group are kept only if the user is allowed to set them, e.g. as root.
+// In CheckMode both contents are held in memory to compute the Diff.
+// Returns an SshResponse with Changed set if the file was changed and an error if any has occured.
+func (s *SshClient) RunOnFileStream(filePath string,
+	transformFunction func(reader io.Reader, writer io.Writer) error) (*SshResponse, error) {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	if s.CheckMode {
+		return s.runOnFileBytes(filePath, EditOptions{}, func(content []byte) ([]byte, error) {
+			var newContent bytes.Buffer
+			err := transformFunction(bytes.NewReader(content), &newContent)
+			return newContent.Bytes(), err
+		})
+	}
+	filePath, err := s.expandRemotePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := s.remoteFileMode(filePath)
+	if err != nil {
+		return nil, err
+	}
+	readSession, err := s.connection.NewSession()
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer readSession.Close()
+	writeSession, err := s.connection.NewSession()
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while establishing a session: " + err.Error())
+	}
+	defer writeSession.Close()
+
+	response := new(SshResponse)
+	response.Address = s.Address
+	readSession.Stderr = &response.StdErr
+	remoteContent, err := readSession.StdoutPipe()
+	if err != nil {
+		return response, err
+	}
+	newContent, err := writeSession.StdinPipe()
+	if err != nil {
+		return response, err
+	}
+	// the temporary file the new content is written to is printed first
+	var temporaryPath, writeErrors bytes.Buffer
+	writeSession.Stdout = &temporaryPath
+	// both sessions run at the same time, their errors are joined once they finish
+	writeSession.Stderr = &writeErrors
+	if err = readSession.Start("cat " + shellQuote(filePath)); err != nil {
+		return response, NewSshConnectionError("There was an error while reading " + filePath + ": " + err.Error())
+	}
+	writeCommand := fmt.Sprintf(`tmp=$(mktemp %s) && echo "$tmp" && cat > "$tmp"`,
+		shellQuote(path.Join(path.Dir(filePath), ".gosher.XXXXXX")))
+	if err = writeSession.Start(writeCommand); err != nil {
+		return response, NewSshConnectionError("There was an error while writing " + filePath + ": " + err.Error())
+	}
+
+	// the checksums tell whether the content changed
+	oldHash, newHash := sha256.New(), sha256.New()
+	reader := io.TeeReader(remoteContent, oldHash)
+	transformErr := transformFunction(reader, io.MultiWriter(newContent, newHash))
+	if transformErr == nil {
+		// the rest of the file, which the function didn't read, is part of the old content
+		_, transformErr = io.Copy(oldHash, remoteContent)
+	} else {
+		io.Copy(ioutil.Discard, remoteContent)
+	}
+	newContent.Close()
+	readErr := readSession.Wait()
+	writeErr := writeSession.Wait()
+	response.StdErr.Write(writeErrors.Bytes())
+	temporaryFile := strings.TrimSpace(temporaryPath.String())
+
+	var commitCommand string
+	switch {
+	case transformErr != nil || readErr != nil || writeErr != nil:
+		commitCommand = "rm -f " + shellQuote(temporaryFile)
+	case bytes.Equal(oldHash.Sum(nil), newHash.Sum(nil)):
+		commitCommand = "rm -f " + shellQuote(temporaryFile)
+	default:
+		response.Changed = true
+		// chown clears the setuid and setgid bits, so the mode is set after it
+		commitCommand = fmt.Sprintf("%s && chmod %s %s && mv -f %s %s", copyOwnerCommand(filePath, shellQuote(temporaryFile)),
+			chmodMode(mode), shellQuote(temporaryFile), shellQuote(temporaryFile), shellQuote(filePath))
+	}
+	if temporaryFile != "" {
+		if err = s.runInNewSession(commitCommand, nil, nil); err != nil {
+			return response, NewSshConnectionError("There was an error while writing " + filePath + ": " + err.Error())
+		}
+	}
+	switch {
+	case transformErr != nil:
+		return response, transformErr
+	case readErr != nil:
+		return response, NewSshConnectionError("There was an error while reading " + filePath + ": " + readErr.Error())
+	case writeErr != nil:
+		return response, NewSshConnectionError("There was an error while writing " + filePath + ": " + writeErr.Error())
+	}
+	return response, nil
+}
+
+// Returns the unified diff between two versions of a file or a note if they are binary.
+func contentDiff(filePath string, oldContent []byte, newContent []byte) string {
+	if bytes.IndexByte(oldContent, 0) >= 0 || bytes.IndexByte(newContent, 0) >= 0 {
+		if bytes.Equal(oldContent, newContent) {
+			return ""
+		}
+		return fmt.Sprintf("Binary files a/%s and b/%s differ\n",
+			strings.TrimPrefix(filePath, "/"), strings.TrimPrefix(filePath, "/"))
+	}
+	return unifiedDiff(filePath, string(oldContent), string(newContent))
+}
+
+// Returns the permissions of a remote file.
+func (s *SshClient) remoteFileMode(remotePath string) (os.FileMode, error) {
+	quotedPath := shellQuote(remotePath)
+	modeOutput, err := s.output(fmt.Sprintf("stat -c %%a %s 2>/dev/null || stat -f %%Lp %s", quotedPath, quotedPath))
+	if err != nil {
+		return 0, NewSshConnectionError("There is no such file: " + remotePath)
+	}
+	mode, err := parseRemoteMode(modeOutput)
+	if err != nil {
+		return 0, NewSshConnectionError("Unexpected mode of " + remotePath + ": " + modeOutput)
+	}
+	return mode, nil
+}
@@ -0,0 +1,122 @@
+package gosher
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckModeDoesNotWrite(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("printf 'original\\n' > checkfile")
+	assert.Nil(t, runError, "creating the test file returned an error")
+	s.CheckMode = true
+
+	response, runOnFileError := s.RunOnFile("checkfile", func(fileContent string) string {
+		return strings.ToUpper(fileContent)
+	})
+	assert.Nil(t, runOnFileError, "RunOnFile returned an error")
+	assert.True(t, response.Changed, "the file should be reported as changed")
+	assert.Equal(t, "--- a/checkfile\n+++ b/checkfile\n@@ -1,1 +1,1 @@\n-original\n+ORIGINAL\n", response.Diff)
+
+	ioutil.WriteFile("checkfile", []byte("uploaded\n"), 0644)
+	defer os.Remove("checkfile")
+	response, uploadError := s.Upload("checkfile", "checkfile")
+	assert.Nil(t, uploadError, "Upload returned an error")
+	assert.True(t, response.Changed, "the file should be reported as changed")
+
+	catResponse, catError := s.Run("cat checkfile")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "original\n", catResponse.StdOut.String(), "nothing should be written in CheckMode")
+}
+
+func TestRunOnFileBytes(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("printf 'a\\000b' > ~/bytesfile && chmod 600 ~/bytesfile")
+	assert.Nil(t, runError, "creating the test file returned an error")
+	ownerResponse, ownerError := s.Run("stat -c %U:%G ~/bytesfile")
+	assert.Nil(t, ownerError, "reading the owner of the test file returned an error")
+
+	response, runOnFileError := s.RunOnFileBytes("~/bytesfile", func(content []byte) ([]byte, error) {
+		assert.Equal(t, []byte("a\x00b"), content, "the binary content should be read as it is")
+		return append(content, 'c'), nil
+	})
+	assert.Nil(t, runOnFileError, "RunOnFileBytes returned an error for a path in the home directory")
+	assert.True(t, response.Changed, "the file should be reported as changed")
+	assert.Equal(t, "", response.Diff, "the Diff should only be computed when asked for")
+
+	statResponse, statError := s.Run("stat -c '%a %U:%G' ~/bytesfile && cat ~/bytesfile")
+	assert.Nil(t, statError, "reading the test file returned an error")
+	assert.Equal(t, "600 "+ownerResponse.StdOut.String()+"a\x00bc", statResponse.StdOut.String(),
+		"the content should be replaced keeping the mode, owner and group")
+
+	response, runOnFileError = s.RunOnFileBytes("~/bytesfile", func(content []byte) ([]byte, error) {
+		return content, nil
+	})
+	assert.Nil(t, runOnFileError, "RunOnFileBytes returned an error")
+	assert.False(t, response.Changed, "an unchanged file shouldn't be reported as changed")
+}
+
+func TestRunOnFileStream(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("printf 'first\\nsecond\\n' > ~/streamfile && chmod 640 ~/streamfile")
+	assert.Nil(t, runError, "creating the test file returned an error")
+
+	response, runOnFileError := s.RunOnFileStream("~/streamfile", func(reader io.Reader, writer io.Writer) error {
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte(strings.ToUpper(string(content))))
+		return err
+	})
+	assert.Nil(t, runOnFileError, "RunOnFileStream returned an error for a path in the home directory")
+	assert.True(t, response.Changed, "the file should be reported as changed")
+
+	response, runOnFileError = s.RunOnFileStream("~/streamfile", func(reader io.Reader, writer io.Writer) error {
+		// only the first line is read, the rest is still part of the old content
+		line := make([]byte, 6)
+		if _, err := io.ReadFull(reader, line); err != nil {
+			return err
+		}
+		_, err := writer.Write(line)
+		return err
+	})
+	assert.Nil(t, runOnFileError, "RunOnFileStream returned an error")
+	assert.True(t, response.Changed, "writing only a part of the content should change the file")
+
+	statResponse, statError := s.Run("stat -c %a ~/streamfile && cat ~/streamfile && ls -a | grep -c '^.gosher' || true")
+	assert.Nil(t, statError, "reading the test file returned an error")
+	assert.Equal(t, "640\nFIRST\n0\n", statResponse.StdOut.String(),
+		"the content should be replaced keeping the mode without leaving temporary files")
+}
+
+func TestRunOnFileTransformError(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("printf 'original\\n' > transformfile")
+	assert.Nil(t, runError, "creating the test file returned an error")
+	transformError := errors.New("invalid content")
+
+	_, runOnFileError := s.RunOnFileBytes("transformfile", func(content []byte) ([]byte, error) {
+		return []byte("changed\n"), transformError
+	})
+	assert.Equal(t, transformError, runOnFileError, "the error of the transformer should be returned")
+	_, runOnFileError = s.RunOnFileStream("transformfile", func(reader io.Reader, writer io.Writer) error {
+		writer.Write([]byte("changed\n"))
+		return transformError
+	})
+	assert.Equal(t, transformError, runOnFileError, "the error of the transformer should be returned")
+
+	catResponse, catError := s.Run("cat transformfile && ls -a | grep -c '^.gosher' || true")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "original\n0\n", catResponse.StdOut.String(),
+		"the file should be left untouched without temporary files")
+}
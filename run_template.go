@@ -0,0 +1,104 @@
+package gosher
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"text/template"
+)
+
+// Renders the text/template commandTemplate for every host and executes the result on it
+// in a separate goroutine for each, e.g. "start --node-id={{.Index}} --peer={{.Vars.peer}}".
+// The template is rendered with the address, alias, index, Vars and facts of the host, see TemplateData,
+// using a variable which the host doesn't have fails the host.
+// The result from execution is passed via the hosts' channels,
+// an error is returned without running anything if the template can't be parsed.
+func (msc *MultipleHostsSshClient) RunTemplate(commandTemplate string) error {
+	task, err := msc.templateTask(commandTemplate)
+	if err != nil {
+		return err
+	}
+	msc.forEachHost(task)
+	return nil
+}
+
+// Executes RunTemplate and blocks until all hosts finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) RunTemplateAll(commandTemplate string) ([]HostResult, error) {
+	task, err := msc.templateTask(commandTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return msc.collect(task), nil
+}
+
+// Executes commands[i] on the host with index i in a separate goroutine for each.
+// The result from execution is passed via the hosts' channels,
+// an error is returned without running anything if there isn't a command for every host.
+func (msc *MultipleHostsSshClient) RunEach(commands []string) error {
+	if err := msc.checkCommands(commands); err != nil {
+		return err
+	}
+	msc.forEachHost(commandsTask(commands))
+	return nil
+}
+
+// Executes RunEach and blocks until all hosts finish.
+// Returns the results in the order of the hosts.
+func (msc *MultipleHostsSshClient) RunEachAll(commands []string) ([]HostResult, error) {
+	if err := msc.checkCommands(commands); err != nil {
+		return nil, err
+	}
+	return msc.collect(commandsTask(commands)), nil
+}
+
+func (msc *MultipleHostsSshClient) checkCommands(commands []string) error {
+	if len(commands) != len(msc.Hosts) {
+		return errors.New("There are " + strconv.Itoa(len(commands)) + " commands for " +
+			strconv.Itoa(len(msc.Hosts)) + " hosts")
+	}
+	return nil
+}
+
+func commandsTask(commands []string) hostTask {
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		return client.Run(commands[index])
+	}
+}
+
+func (msc *MultipleHostsSshClient) templateTask(commandTemplate string) (hostTask, error) {
+	parsedTemplate, err := template.New("command").Option("missingkey=error").Parse(commandTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return func(index int, client *SshClient) (*SshResponse, error) {
+		command, err := msc.renderCommand(parsedTemplate, index, client)
+		if err != nil {
+			return nil, err
+		}
+		return client.Run(command)
+	}, nil
+}
+
+// Renders the command of the host with the given index, client is used to gather its facts.
+func (msc *MultipleHostsSshClient) renderCommand(parsedTemplate *template.Template, index int,
+	client *SshClient) (string, error) {
+	data := msc.templateData(index)
+	data.client = client
+	var rendered bytes.Buffer
+	if err := parsedTemplate.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// Returns the data the templates of the host with the given index are rendered with.
+func (msc *MultipleHostsSshClient) templateData(index int) *TemplateData {
+	host := msc.Hosts[index]
+	return &TemplateData{
+		Address: host.Client.Address,
+		Alias:   host.Name(),
+		Index:   index,
+		Vars:    host.Vars,
+	}
+}
@@ -0,0 +1,30 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"text/template"
+)
+
+func TestRenderCommand(t *testing.T) {
+	msc := newTestHosts("10.0.0.1", "10.0.0.2")
+	msc.Hosts[0].Alias = "node-a"
+	msc.Hosts[0].Vars = map[string]interface{}{"peer": "10.0.0.2"}
+	msc.Hosts[1].Vars = map[string]interface{}{}
+	parsedTemplate := template.Must(template.New("command").Option("missingkey=error").
+		Parse("start --node-id={{.Index}} --name={{.Alias}} --bind={{.Address}} --peer={{.Vars.peer}}"))
+
+	command, err := msc.renderCommand(parsedTemplate, 0, msc.Hosts[0].Client)
+	assert.Nil(t, err)
+	assert.Equal(t, "start --node-id=0 --name=node-a --bind=10.0.0.1 --peer=10.0.0.2", command)
+	_, err = msc.renderCommand(parsedTemplate, 1, msc.Hosts[1].Client)
+	assert.NotNil(t, err, "a variable which the host doesn't have should fail")
+}
+
+func TestRunTemplateErrors(t *testing.T) {
+	msc := newTestHosts("10.0.0.1", "10.0.0.2")
+	_, err := msc.RunTemplateAll("start {{.Index")
+	assert.NotNil(t, err)
+	_, err = msc.RunEachAll([]string{"uptime"})
+	assert.NotNil(t, err, "there should be a command for every host")
+}
@@ -0,0 +1,61 @@
+package scp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func FuzzParseRecord(f *testing.F) {
+	for _, seed := range []string{"C0644 12 file.txt", "D0755 0 dir", "E", "T1700000000 0 1700000000 0",
+		"C0644 1 ../escape", "C0644 99999999999999999999 big"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		record, err := ParseRecord(line)
+		if err != nil {
+			return
+		}
+		if record.Type == File || record.Type == Directory {
+			if record.Name == ".." || bytes.ContainsAny([]byte(record.Name), "/\x00") || record.Size < 0 {
+				t.Fatalf("unsafe record accepted: %q", line)
+			}
+			reparsed, err := ParseRecord(FormatRecord(record)[:len(FormatRecord(record))-1])
+			if err != nil || reparsed != record {
+				t.Fatalf("formatting %q doesn't round trip", line)
+			}
+		}
+	})
+}
+
+func FuzzSink(f *testing.F) {
+	f.Add([]byte("C0644 5 file\nhello\x00D0755 0 dir\nE\n"))
+	f.Add([]byte("T1 0 1 0\nC0644 1 f\nx\x01scp: warning\n"))
+	f.Add([]byte("\x01scp: missing\nC0600 0 empty\n\x00"))
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		sink := NewSink(bytes.NewReader(stream), ioutil.Discard)
+		// every record consumes input, so this ends
+		for i := 0; i <= len(stream); i++ {
+			record, err := sink.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil && !IsWarning(err) {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			if record.Type == File {
+				err = sink.ReceiveFile(record, ioutil.Discard)
+			} else {
+				err = sink.Ack()
+			}
+			if err != nil && !IsWarning(err) {
+				return
+			}
+		}
+		t.Fatal("the sink didn't finish")
+	})
+}
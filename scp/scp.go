@@ -0,0 +1,251 @@
+// Package scp implements both sides of the scp protocol as spoken by
+// scp -t (the sink, which receives files) and scp -f (the source, which sends them):
+// the C (file), D (directory), E (end of directory) and T (times) records,
+// the acknowledgements after every record and file, and the warning (0x1)
+// and fatal (0x2) error responses.
+package scp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record types.
+const (
+	File         = 'C'
+	Directory    = 'D'
+	EndDirectory = 'E'
+	Times        = 'T'
+)
+
+// Response bytes, Warning and Fatal are followed by a message ending with a newline.
+const (
+	OK      = 0x0
+	Warning = 0x1
+	Fatal   = 0x2
+)
+
+// The longest record line which is accepted, names are limited by PATH_MAX anyway.
+const maxRecordLength = 64 * 1024
+
+// Record - a message of the protocol.
+// Mode, Size and Name are set for File and Directory records, the size of directories is 0.
+// ModTime and AccessTime are set if the record was preceded by a Times record, they are zero otherwise.
+type Record struct {
+	Type       byte
+	Mode       os.FileMode
+	Size       int64
+	Name       string
+	ModTime    time.Time
+	AccessTime time.Time
+}
+
+// RemoteError - an error reported by the other side of the transfer.
+// A warning (Fatal is false) concerns a single file and the transfer goes on,
+// a fatal error ends it.
+type RemoteError struct {
+	Fatal   bool
+	Message string
+}
+
+func (re *RemoteError) Error() string {
+	return re.Message
+}
+
+// Reports whether err is a warning of the other side, after which the transfer can go on.
+func IsWarning(err error) bool {
+	remoteErr, ok := err.(*RemoteError)
+	return ok && !remoteErr.Fatal
+}
+
+// ProtocolError - the other side sent something which isn't valid scp.
+type ProtocolError struct {
+	Message string
+}
+
+func (pe *ProtocolError) Error() string {
+	return "scp protocol error: " + pe.Message
+}
+
+// ContentError - the content of a single file couldn't be read by the source or written by the sink,
+// the other side was told about it and the transfer can go on.
+type ContentError struct {
+	Name string
+	Err  error
+}
+
+func (ce *ContentError) Error() string {
+	return ce.Name + ": " + ce.Err.Error()
+}
+
+// Reports whether err concerns only a single file, a warning of the other side or a ContentError,
+// after which the transfer can go on.
+func IsFileError(err error) bool {
+	_, isContentError := err.(*ContentError)
+	return isContentError || IsWarning(err)
+}
+
+func newProtocolError(format string, arguments ...interface{}) *ProtocolError {
+	return &ProtocolError{Message: fmt.Sprintf(format, arguments...)}
+}
+
+// Parses a record line without its trailing newline, e.g. "C0644 12 file.txt".
+// Names containing slashes, "." and ".." are rejected, so a record can't lead out of the target directory.
+func ParseRecord(line string) (Record, error) {
+	if line == "" {
+		return Record{}, newProtocolError("empty record")
+	}
+	record := Record{Type: line[0]}
+	switch record.Type {
+	case File, Directory:
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return Record{}, newProtocolError("malformed record %q", line)
+		}
+		if len(fields[0]) != 4 {
+			return Record{}, newProtocolError("bad mode %q", fields[0])
+		}
+		mode, err := parseDigits(fields[0], 8, 32)
+		if err != nil {
+			return Record{}, newProtocolError("bad mode %q", fields[0])
+		}
+		record.Mode = parseMode(mode)
+		size, err := parseDigits(fields[1], 10, 63)
+		if err != nil {
+			return Record{}, newProtocolError("bad size %q", fields[1])
+		}
+		record.Size = int64(size)
+		record.Name = fields[2]
+		if record.Name == "" || record.Name == "." || record.Name == ".." || strings.ContainsAny(record.Name, "/\x00") {
+			return Record{}, newProtocolError("unsafe name %q", record.Name)
+		}
+	case EndDirectory:
+		if line != "E" {
+			return Record{}, newProtocolError("malformed record %q", line)
+		}
+	case Times:
+		fields := strings.Split(line[1:], " ")
+		if len(fields) != 4 {
+			return Record{}, newProtocolError("malformed record %q", line)
+		}
+		var values [4]int64
+		for i, field := range fields {
+			value, err := parseDigits(field, 10, 63)
+			if err != nil || i%2 == 1 && value > 999999 {
+				return Record{}, newProtocolError("bad time %q", field)
+			}
+			values[i] = int64(value)
+		}
+		record.ModTime = time.Unix(values[0], values[1]*1000)
+		record.AccessTime = time.Unix(values[2], values[3]*1000)
+	default:
+		return Record{}, newProtocolError("unknown record %q", line)
+	}
+	return record, nil
+}
+
+// Parses an unsigned number which consists only of digits, without a sign or spaces.
+func parseDigits(field string, base int, bits int) (uint64, error) {
+	if field == "" || field[0] == '+' || field[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseUint(field, base, bits)
+}
+
+// Converts the octal mode of a record, its setuid, setgid and sticky bits
+// become os.ModeSetuid, os.ModeSetgid and os.ModeSticky.
+func parseMode(bits uint64) os.FileMode {
+	mode := os.FileMode(bits).Perm()
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// Returns the octal mode of a record for mode, the reverse of parseMode.
+func formatMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// Formats a record line including its trailing newline, the Times of a record are sent separately.
+func FormatRecord(record Record) string {
+	switch record.Type {
+	case File, Directory:
+		return fmt.Sprintf("%c%04o %d %s\n", record.Type, formatMode(record.Mode), record.Size, record.Name)
+	case Times:
+		return fmt.Sprintf("T%d %d %d %d\n", record.ModTime.Unix(), record.ModTime.Nanosecond()/1000,
+			record.AccessTime.Unix(), record.AccessTime.Nanosecond()/1000)
+	}
+	return string(record.Type) + "\n"
+}
+
+// Reads the line after a record type byte or an error response, without its newline.
+func readLine(reader *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(line) > maxRecordLength {
+			return "", newProtocolError("record longer than %d bytes", maxRecordLength)
+		}
+		if err == nil {
+			return string(line[:len(line)-1]), nil
+		}
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+}
+
+// Reads a response of the other side: nil for OK, a RemoteError for a warning or a fatal error.
+func readResponse(reader *bufio.Reader) error {
+	response, err := reader.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	switch response {
+	case OK:
+		return nil
+	case Warning, Fatal:
+		message, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		return &RemoteError{Fatal: response == Fatal, Message: strings.TrimPrefix(message, "scp: ")}
+	}
+	return newProtocolError("unexpected response 0x%02x", response)
+}
+
+// Writes an error response, the newlines of the message are replaced as they end it.
+func writeError(writer io.Writer, response byte, message string) error {
+	message = strings.Replace(message, "\n", " ", -1)
+	_, err := writer.Write([]byte(string(response) + message + "\n"))
+	return err
+}
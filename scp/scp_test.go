@@ -0,0 +1,143 @@
+package scp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecord(t *testing.T) {
+	record, err := ParseRecord("C0644 12 file name.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, Record{Type: File, Mode: 0644, Size: 12, Name: "file name.txt"}, record)
+	record, err = ParseRecord("T1700000000 5 1700000001 0")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Unix(1700000000, 5000), record.ModTime)
+	record, err = ParseRecord("C4755 1 tool")
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, record.Mode, "the setuid bit should be parsed")
+	for _, line := range []string{"", "C0644 12", "C644 12 f", "C0644 -1 f", "C0644 1 ../f", "C0644 1 a/b",
+		"D0755 0 ..", "E0", "T1 1000000 1 0", "X"} {
+		_, err = ParseRecord(line)
+		assert.NotNil(t, err, "%q should be rejected", line)
+	}
+}
+
+func TestFormatRecord(t *testing.T) {
+	assert.Equal(t, "D0755 0 etc\n", FormatRecord(Record{Type: Directory, Mode: 0755, Name: "etc"}))
+	assert.Equal(t, "E\n", FormatRecord(Record{Type: EndDirectory}))
+	assert.Equal(t, "D3770 0 shared\n", FormatRecord(Record{Type: Directory, Mode: os.ModeSetgid | os.ModeSticky | 0770,
+		Name: "shared"}), "the setgid and sticky bits should be sent")
+}
+
+// Connects a Source and a Sink through pipes and runs receive on the sink side.
+func transfer(send func(source *Source) error, receive func(sink *Sink) error) (error, error) {
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+	receiveErrors := make(chan error, 1)
+	go func() {
+		err := receive(NewSink(toSink, fromSink))
+		fromSink.Close()
+		toSink.Close()
+		receiveErrors <- err
+	}()
+	sendErr := send(NewSource(fromSource, toSource))
+	fromSource.Close()
+	return sendErr, <-receiveErrors
+}
+
+func TestTransfer(t *testing.T) {
+	var received []string
+	sendErr, receiveErr := transfer(func(source *Source) error {
+		if err := source.Times(time.Unix(1, 0), time.Unix(2, 0)); err != nil {
+			return err
+		}
+		if err := source.Directory(0755, "etc"); err != nil {
+			return err
+		}
+		if err := source.File(0600, 5, "hosts", strings.NewReader("hello")); err != nil {
+			return err
+		}
+		return source.EndDirectory()
+	}, func(sink *Sink) error {
+		for {
+			record, err := sink.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if record.Type == File {
+				var content bytes.Buffer
+				if err = sink.ReceiveFile(record, &content); err != nil {
+					return err
+				}
+				received = append(received, record.Name+"="+content.String())
+				continue
+			}
+			received = append(received, FormatRecord(record)+record.ModTime.String())
+			if err = sink.Ack(); err != nil {
+				return err
+			}
+		}
+	})
+	assert.Nil(t, sendErr)
+	assert.Nil(t, receiveErr)
+	assert.Equal(t, 3, len(received))
+	assert.Equal(t, "D0755 0 etc\n"+time.Unix(1, 0).String(), received[0], "times should precede the directory")
+	assert.Equal(t, "hosts=hello", received[1])
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestTransferWarnings(t *testing.T) {
+	var receiveErrors []error
+	sendErr, _ := transfer(func(source *Source) error {
+		rejectedErr := source.File(0644, 3, "rejected", strings.NewReader("abc"))
+		assert.True(t, IsWarning(rejectedErr), "a rejected file should be a warning")
+		failedErr := source.File(0644, 3, "failed", strings.NewReader("abc"))
+		assert.Equal(t, "failed: disk full", failedErr.Error(), "the error of the sink should be returned")
+		unreadableErr := source.File(0644, 3, "unreadable", strings.NewReader("a"))
+		assert.Equal(t, &ContentError{Name: "unreadable", Err: io.ErrUnexpectedEOF}, unreadableErr,
+			"a short file should fail")
+		return source.File(0644, 2, "ok", strings.NewReader("ok"))
+	}, func(sink *Sink) error {
+		for {
+			record, err := sink.Next()
+			if err != nil {
+				return err
+			}
+			switch record.Name {
+			case "rejected":
+				err = sink.Reject("rejected: permission denied")
+			case "failed":
+				err = sink.ReceiveFile(record, failingWriter{})
+			default:
+				err = sink.ReceiveFile(record, &bytes.Buffer{})
+			}
+			receiveErrors = append(receiveErrors, err)
+		}
+	})
+	assert.Nil(t, sendErr, "the transfer should go on after warnings")
+	assert.Equal(t, 4, len(receiveErrors))
+	assert.True(t, IsFileError(receiveErrors[1]), "a failed write should concern only the file")
+	assert.True(t, IsWarning(receiveErrors[2]), "the sink should get the warning of the source")
+	assert.Nil(t, receiveErrors[3])
+}
+
+func TestSinkFatalError(t *testing.T) {
+	var acks bytes.Buffer
+	sink := NewSink(strings.NewReader("\x02scp: /root: Permission denied\n"), &acks)
+	_, err := sink.Next()
+	assert.Equal(t, &RemoteError{Fatal: true, Message: "/root: Permission denied"}, err)
+}
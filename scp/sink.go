@@ -0,0 +1,135 @@
+package scp
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Sink - the receiving side of a transfer, talking to a source like scp -f.
+// Records are read from reader and the acknowledgements are written to writer.
+// Every record returned by Next has to be answered: File records with ReceiveFile or Reject,
+// the rest with Ack or Reject.
+type Sink struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	started bool
+}
+
+func NewSink(reader io.Reader, writer io.Writer) *Sink {
+	return &Sink{
+		reader: bufio.NewReader(reader),
+		writer: writer,
+	}
+}
+
+// Returns the next File, Directory or EndDirectory record.
+// Times records are acknowledged and set on the record they precede.
+// Returns io.EOF when the source has finished, a RemoteError if the source reported an error,
+// after a warning the next record can be read.
+func (s *Sink) Next() (Record, error) {
+	if !s.started {
+		// the source waits for the sink to be ready
+		s.started = true
+		if err := s.Ack(); err != nil {
+			return Record{}, err
+		}
+	}
+	var modTime, accessTime time.Time
+	for {
+		recordType, err := s.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && !modTime.IsZero() {
+				err = io.ErrUnexpectedEOF
+			}
+			return Record{}, err
+		}
+		if recordType == Warning || recordType == Fatal {
+			s.reader.UnreadByte()
+			return Record{}, readResponse(s.reader)
+		}
+		line, err := readLine(s.reader)
+		if err != nil {
+			return Record{}, err
+		}
+		record, err := ParseRecord(string(recordType) + line)
+		if err != nil {
+			return Record{}, err
+		}
+		if record.Type != Times {
+			record.ModTime, record.AccessTime = modTime, accessTime
+			return record, nil
+		}
+		if err = s.Ack(); err != nil {
+			return Record{}, err
+		}
+		modTime, accessTime = record.ModTime, record.AccessTime
+	}
+}
+
+// Acknowledges the last record.
+func (s *Sink) Ack() error {
+	_, err := s.writer.Write([]byte{OK})
+	return err
+}
+
+// Rejects the last record with a warning, the source goes on with the next one.
+func (s *Sink) Reject(message string) error {
+	return writeError(s.writer, Warning, message)
+}
+
+// Aborts the transfer.
+func (s *Sink) Fatal(message string) error {
+	return writeError(s.writer, Fatal, message)
+}
+
+// Accepts a File record and writes its content to writer.
+// If writer fails the rest of the content is discarded, the source is told about the failure
+// and the error of writer is returned in a ContentError, the transfer can go on.
+// Returns a RemoteError if the source reported that it couldn't read the file.
+func (s *Sink) ReceiveFile(record Record, writer io.Writer) error {
+	if err := s.Ack(); err != nil {
+		return err
+	}
+	content := &io.LimitedReader{R: s.reader, N: record.Size}
+	_, err := io.Copy(markedWriter{writer}, content)
+	if err == nil && content.N > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	var writeErr error
+	if err != nil {
+		failure, isWriteFailure := err.(writeFailure)
+		if !isWriteFailure {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		writeErr = failure.err
+		// the source sends the whole file anyway
+		if _, err = io.CopyN(ioutil.Discard, s.reader, content.N); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	// the source ends the content with its own response
+	if sourceErr := readResponse(s.reader); sourceErr != nil {
+		if _, isRemote := sourceErr.(*RemoteError); !isRemote {
+			return sourceErr
+		}
+		if err = s.Ack(); err != nil {
+			return err
+		}
+		return sourceErr
+	}
+	if writeErr != nil {
+		if err = s.Reject(record.Name + ": " + writeErr.Error()); err != nil {
+			return err
+		}
+		return &ContentError{Name: record.Name, Err: writeErr}
+	}
+	return s.Ack()
+}
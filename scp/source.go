@@ -0,0 +1,142 @@
+package scp
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+)
+
+// Source - the sending side of a transfer, talking to a sink like scp -t.
+// Records are written to writer and the acknowledgements of the sink are read from reader.
+// Every method waits for the acknowledgement of the sink and returns a RemoteError
+// if the sink reported an error, after a warning the transfer can go on.
+type Source struct {
+	writer  io.Writer
+	reader  *bufio.Reader
+	started bool
+}
+
+func NewSource(writer io.Writer, reader io.Reader) *Source {
+	return &Source{
+		writer: writer,
+		reader: bufio.NewReader(reader),
+	}
+}
+
+// Waits for the sink to be ready, which it signals with an acknowledgement when it starts.
+func (s *Source) start() error {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return readResponse(s.reader)
+}
+
+// Sends a record and waits for its acknowledgement.
+func (s *Source) send(record Record) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(s.writer, FormatRecord(record)); err != nil {
+		return err
+	}
+	return readResponse(s.reader)
+}
+
+// Sends the modification and access times of the next file or directory.
+func (s *Source) Times(modTime time.Time, accessTime time.Time) error {
+	return s.send(Record{Type: Times, ModTime: modTime, AccessTime: accessTime})
+}
+
+// Sends a file of the given size with its content read from content.
+// If the sink rejects the file with a warning nothing is read from content.
+// If content can't be read the rest of the file is padded with zeros,
+// the sink is told to discard it and a ContentError is returned.
+func (s *Source) File(mode os.FileMode, size int64, name string, content io.Reader) error {
+	if err := s.send(Record{Type: File, Mode: mode, Size: size, Name: name}); err != nil {
+		return err
+	}
+	written, readErr := io.CopyN(markedWriter{s.writer}, readerOnly{content}, size)
+	if readErr != nil {
+		if failure, isWriteFailure := readErr.(writeFailure); isWriteFailure {
+			return failure.err
+		}
+		if readErr == io.EOF {
+			readErr = io.ErrUnexpectedEOF
+		}
+		// the sink expects exactly size bytes
+		if _, err := io.CopyN(s.writer, zeroReader{}, size-written); err != nil {
+			return err
+		}
+		if err := writeError(s.writer, Warning, name+": "+readErr.Error()); err != nil {
+			return err
+		}
+		if err := readResponse(s.reader); err != nil && !IsWarning(err) {
+			return err
+		}
+		return &ContentError{Name: name, Err: readErr}
+	}
+	if _, err := s.writer.Write([]byte{OK}); err != nil {
+		return err
+	}
+	return readResponse(s.reader)
+}
+
+// Begins a directory, the following records are in it until EndDirectory.
+func (s *Source) Directory(mode os.FileMode, name string) error {
+	return s.send(Record{Type: Directory, Mode: mode, Name: name})
+}
+
+// Ends the current directory.
+func (s *Source) EndDirectory() error {
+	return s.send(Record{Type: EndDirectory})
+}
+
+// Tells the sink that a file couldn't be sent instead of its record, the transfer goes on.
+func (s *Source) Warning(message string) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	return writeError(s.writer, Warning, message)
+}
+
+// Tells the sink that the transfer is aborted.
+func (s *Source) Fatal(message string) error {
+	return writeError(s.writer, Fatal, message)
+}
+
+// readerOnly hides the WriterTo of a reader, so io.CopyN reports read errors as they are.
+type readerOnly struct {
+	io.Reader
+}
+
+// markedWriter marks its errors, so they can be told apart from the errors of the reader of io.CopyN.
+type markedWriter struct {
+	writer io.Writer
+}
+
+func (mw markedWriter) Write(p []byte) (int, error) {
+	n, err := mw.writer.Write(p)
+	if err != nil {
+		err = writeFailure{err}
+	}
+	return n, err
+}
+
+type writeFailure struct {
+	err error
+}
+
+func (wf writeFailure) Error() string {
+	return wf.err.Error()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
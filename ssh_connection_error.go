@@ -3,8 +3,10 @@ package gosher
 // Standard error returned on all ssh operations
 // This means there was an error with the connection or the command
 // returned an error code different from 0.
+// The error which caused it, like an *ssh.ExitError, can be found with errors.As.
 type SshConnectionError struct {
 	errorMessage string
+	cause        error
 }
 
 // Returns the error message of the SshConnectionError
@@ -12,8 +14,21 @@ func (se *SshConnectionError) Error() string {
 	return se.errorMessage
 }
 
+// Returns the error which caused the SshConnectionError, nil if it isn't known.
+func (se *SshConnectionError) Unwrap() error {
+	return se.cause
+}
+
 func NewSshConnectionError(errorMessage string) *SshConnectionError {
 	return &SshConnectionError{
 		errorMessage: errorMessage,
 	}
 }
+
+// Returns an SshConnectionError with the message followed by the message of its cause.
+func wrapSshConnectionError(errorMessage string, cause error) *SshConnectionError {
+	return &SshConnectionError{
+		errorMessage: errorMessage + cause.Error(),
+		cause:        cause,
+	}
+}
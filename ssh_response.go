@@ -6,10 +6,17 @@ import (
 )
 
 // Standard response returned from ssh operations
+// Changed - reported by the operations which only modify a remote file if needed,
+// true if its content or mode was changed.
+// Diff - the unified diff of the changed remote file, reported in CheckMode or if asked for by EditOptions.Diff.
+// ExitCode - the exit status of the command of Run and RunScript, 0 if it succeeded or didn't finish.
 type SshResponse struct {
-	Address string
-	StdOut  bytes.Buffer
-	StdErr  bytes.Buffer
+	Address  string
+	StdOut   bytes.Buffer
+	StdErr   bytes.Buffer
+	Changed  bool
+	Diff     string
+	ExitCode int
 }
 
 func NewSshResponse(host string, session *ssh.Session) *SshResponse {
@@ -19,3 +26,10 @@ func NewSshResponse(host string, session *ssh.Session) *SshResponse {
 	session.Stderr = &response.StdErr
 	return response
 }
+
+// Records the exit status of the command if it exited with an error.
+func (r *SshResponse) setExitCode(err error) {
+	if exitErr, isExitError := err.(*ssh.ExitError); isExitError {
+		r.ExitCode = exitErr.ExitStatus()
+	}
+}
@@ -0,0 +1,397 @@
+package gosher
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SyncUpload = iota
+	SyncDownload
+)
+
+// SyncOptions - options of Sync.
+// Direction - SyncUpload (default) makes remoteDir match localDir,
+// SyncDownload makes localDir match remoteDir.
+// Checksum - compare files by SHA-256 instead of size and modification time.
+// Delete - delete files in the destination which don't exist in the source.
+// Include/Exclude - gitignore-style patterns relative to the synced directory,
+// only the files which are included (all if there are no Include patterns)
+// and not excluded are synced or deleted.
+// DryRun - only list the changes without transferring or deleting anything.
+type SyncOptions struct {
+	Direction int
+	Checksum  bool
+	Delete    bool
+	Include   []string
+	Exclude   []string
+	DryRun    bool
+}
+
+// SyncResult - the files which were (or in DryRun would be) transferred and deleted by Sync.
+// Paths are slash separated and relative to the synced directories.
+type SyncResult struct {
+	Transferred []string
+	Deleted     []string
+}
+
+// State of a file taking part in the comparison.
+type syncFile struct {
+	size    int64
+	modTime int64
+	mode    os.FileMode
+}
+
+// Synchronizes the contents of localDir and remoteDir transferring only the files
+// which differ, see SyncOptions for the direction and comparison.
+// Modification times and permissions of the transferred files are preserved.
+// A leading ~ of remoteDir is the home directory of the remote user.
+// Returns a SyncResult and an error if any has occured.
+func (s *SshClient) Sync(localDir string, remoteDir string, options SyncOptions) (*SyncResult, error) {
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	filter, err := newPathFilter(options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	// the remote paths are quoted in the commands below
+	if remoteDir, err = s.expandRemotePath(remoteDir); err != nil {
+		return nil, err
+	}
+	localFiles, err := listLocalFiles(localDir, filter)
+	if err != nil {
+		return nil, err
+	}
+	remoteFiles, err := s.listRemoteFiles(remoteDir, filter)
+	if err != nil {
+		return nil, err
+	}
+	sourceFiles, destinationFiles := localFiles, remoteFiles
+	if options.Direction == SyncDownload {
+		sourceFiles, destinationFiles = remoteFiles, localFiles
+	}
+	result := new(SyncResult)
+	result.Transferred, err = s.changedFiles(localDir, remoteDir, sourceFiles, destinationFiles, options)
+	if err != nil {
+		return nil, err
+	}
+	if options.Delete {
+		result.Deleted = missingFiles(destinationFiles, sourceFiles)
+	}
+	if options.DryRun {
+		return result, nil
+	}
+	meter := s.newTransferMeter(s.ProgressCallback)
+	if options.Direction == SyncDownload {
+		err = s.syncDown(localDir, remoteDir, sourceFiles, result, meter)
+	} else {
+		err = s.syncUp(localDir, remoteDir, sourceFiles, result, meter)
+	}
+	return result, err
+}
+
+// Returns the sorted paths of the files which are missing in otherFiles.
+func missingFiles(files map[string]syncFile, otherFiles map[string]syncFile) []string {
+	var missing []string
+	for relativePath := range files {
+		if _, ok := otherFiles[relativePath]; !ok {
+			missing = append(missing, relativePath)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Returns the sorted directories of the files, "." for the synced directory itself.
+func fileDirectories(relativePaths []string) []string {
+	directories := map[string]bool{}
+	for _, relativePath := range relativePaths {
+		directories[path.Dir(relativePath)] = true
+	}
+	directoryList := make([]string, 0, len(directories))
+	for directory := range directories {
+		directoryList = append(directoryList, directory)
+	}
+	sort.Strings(directoryList)
+	return directoryList
+}
+
+// Returns the directories which could become empty when the files are deleted,
+// every directory before its parent, the synced directory itself isn't one of them.
+func emptiedDirectories(relativePaths []string) []string {
+	directories := map[string]bool{}
+	for _, relativePath := range relativePaths {
+		for directory := path.Dir(relativePath); directory != "."; directory = path.Dir(directory) {
+			directories[directory] = true
+		}
+	}
+	directoryList := make([]string, 0, len(directories))
+	for directory := range directories {
+		directoryList = append(directoryList, directory)
+	}
+	sort.Slice(directoryList, func(i, j int) bool {
+		iDepth, jDepth := strings.Count(directoryList[i], "/"), strings.Count(directoryList[j], "/")
+		if iDepth != jDepth {
+			return iDepth > jDepth
+		}
+		return directoryList[i] < directoryList[j]
+	})
+	return directoryList
+}
+
+// Returns the sorted paths of the source files which are missing or different in the destination.
+func (s *SshClient) changedFiles(localDir string, remoteDir string, sourceFiles map[string]syncFile,
+	destinationFiles map[string]syncFile, options SyncOptions) ([]string, error) {
+	var changed, sameSize []string
+	for relativePath, source := range sourceFiles {
+		destination, ok := destinationFiles[relativePath]
+		switch {
+		case !ok || source.size != destination.size:
+			changed = append(changed, relativePath)
+		case options.Checksum:
+			sameSize = append(sameSize, relativePath)
+		case source.modTime != destination.modTime:
+			changed = append(changed, relativePath)
+		}
+	}
+	if len(sameSize) > 0 {
+		remotePaths := make([]string, len(sameSize))
+		for i, relativePath := range sameSize {
+			remotePaths[i] = path.Join(remoteDir, relativePath)
+		}
+		remoteChecksums, err := s.remoteChecksums(remotePaths)
+		if err != nil {
+			return nil, err
+		}
+		for i, relativePath := range sameSize {
+			checksum, err := localChecksum(filepath.Join(localDir, filepath.FromSlash(relativePath)), -1)
+			if err != nil {
+				return nil, err
+			}
+			if checksum != remoteChecksums[i] {
+				changed = append(changed, relativePath)
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func (s *SshClient) syncUp(localDir string, remoteDir string, localFiles map[string]syncFile,
+	result *SyncResult, meter *transferMeter) error {
+	if err := s.runOnRelativePaths("rm -f %s", remoteDir, result.Deleted); err != nil {
+		return NewSshConnectionError("There was an error while deleting remote files: " + err.Error())
+	}
+	// the directories which are left empty are deleted, rmdir fails on the others
+	if err := s.runOnRelativePaths("rmdir %s 2>/dev/null || true", remoteDir, emptiedDirectories(result.Deleted)); err != nil {
+		return NewSshConnectionError("There was an error while deleting remote directories: " + err.Error())
+	}
+	if len(result.Transferred) == 0 {
+		return nil
+	}
+	for _, relativePath := range result.Transferred {
+		meter.addTotal(localFiles[relativePath].size)
+	}
+	if err := s.runOnRelativePaths("mkdir -p %s", remoteDir, fileDirectories(result.Transferred)); err != nil {
+		return NewSshConnectionError("There was an error while creating remote directories: " + err.Error())
+	}
+	var attributeCommands []string
+	for _, relativePath := range result.Transferred {
+		localPath := filepath.Join(localDir, filepath.FromSlash(relativePath))
+		remotePath := shellQuote(path.Join(remoteDir, relativePath))
+		file, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		meter.startFile(localPath)
+		// an interrupted upload leaves the previous version of the file
+		uploadCommand := fmt.Sprintf(`tmp=$(mktemp %s) && { cat > "$tmp" && mv -f "$tmp" %s || { rm -f "$tmp"; exit 1; }; }`,
+			shellQuote(path.Join(path.Dir(path.Join(remoteDir, relativePath)), ".gosher.XXXXXX")), remotePath)
+		err = s.runInNewSession(uploadCommand, meter.reader(file), nil)
+		file.Close()
+		if err != nil {
+			return NewSshConnectionError("There was an error while uploading " + localPath + ": " + err.Error())
+		}
+		meter.finishFile()
+		localFile := localFiles[relativePath]
+		attributeCommands = append(attributeCommands,
+			fmt.Sprintf("chmod %o %s && touch -m -d @%d %s", localFile.mode.Perm(), remotePath, localFile.modTime, remotePath))
+	}
+	for start := 0; start < len(attributeCommands); start += remoteBatchSize {
+		end := start + remoteBatchSize
+		if end > len(attributeCommands) {
+			end = len(attributeCommands)
+		}
+		if err := s.runInNewSession(strings.Join(attributeCommands[start:end], " && "), nil, nil); err != nil {
+			return NewSshConnectionError("There was an error while setting file attributes: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Runs the command format with the relative paths joined to dir as its arguments,
+// remoteBatchSize paths at a time.
+func (s *SshClient) runOnRelativePaths(format string, dir string, relativePaths []string) error {
+	for start := 0; start < len(relativePaths); start += remoteBatchSize {
+		end := start + remoteBatchSize
+		if end > len(relativePaths) {
+			end = len(relativePaths)
+		}
+		command := fmt.Sprintf(format, quoteRelativePaths(dir, relativePaths[start:end]))
+		if err := s.runInNewSession(command, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SshClient) syncDown(localDir string, remoteDir string, remoteFiles map[string]syncFile,
+	result *SyncResult, meter *transferMeter) error {
+	for _, relativePath := range result.Deleted {
+		if err := os.Remove(filepath.Join(localDir, filepath.FromSlash(relativePath))); err != nil {
+			return err
+		}
+	}
+	// the directories which are left empty are deleted, Remove fails on the others
+	for _, directory := range emptiedDirectories(result.Deleted) {
+		os.Remove(filepath.Join(localDir, filepath.FromSlash(directory)))
+	}
+	for _, relativePath := range result.Transferred {
+		meter.addTotal(remoteFiles[relativePath].size)
+	}
+	for _, relativePath := range result.Transferred {
+		localPath := filepath.Join(localDir, filepath.FromSlash(relativePath))
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		remoteFile := remoteFiles[relativePath]
+		// an interrupted download leaves the previous version of the file
+		file, err := ioutil.TempFile(filepath.Dir(localPath), ".gosher")
+		if err != nil {
+			return err
+		}
+		meter.startFile(localPath)
+		err = s.runInNewSession("cat "+shellQuote(path.Join(remoteDir, relativePath)), nil, meter.writer(file))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return NewSshConnectionError("There was an error while downloading " + relativePath + ": " + err.Error())
+		}
+		meter.finishFile()
+		modTime := time.Unix(remoteFile.modTime, 0)
+		if err = os.Chmod(file.Name(), remoteFile.mode.Perm()); err == nil {
+			err = os.Chtimes(file.Name(), modTime, modTime)
+		}
+		if err == nil {
+			err = os.Rename(file.Name(), localPath)
+		}
+		if err != nil {
+			os.Remove(file.Name())
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the files under localDir which pass the filter by their relative path.
+func listLocalFiles(localDir string, filter *pathFilter) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	err := filepath.Walk(localDir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && localPath == localDir {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if filter.allowsFile(relativePath) {
+			files[relativePath] = syncFile{
+				size:    info.Size(),
+				modTime: info.ModTime().Unix(),
+				mode:    info.Mode(),
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Returns the files under remoteDir which pass the filter by their relative path.
+// Requires GNU find on the remote machine.
+func (s *SshClient) listRemoteFiles(remoteDir string, filter *pathFilter) (map[string]syncFile, error) {
+	quotedDir := shellQuote(remoteDir)
+	// the records are separated by NUL and the path is last, so it can contain any other character
+	var listing bytes.Buffer
+	err := s.runInNewSession(fmt.Sprintf(`if [ -d %s ]; then find %s -type f -printf '%%s\t%%T@\t%%m\t%%P\0'; fi`,
+		quotedDir, quotedDir), nil, &listing)
+	if err != nil {
+		return nil, NewSshConnectionError("There was an error while listing " + remoteDir + ": " + err.Error())
+	}
+	return parseRemoteFiles(listing.String(), filter)
+}
+
+// Parses the listing of listRemoteFiles.
+func parseRemoteFiles(listing string, filter *pathFilter) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	for _, record := range strings.Split(listing, "\x00") {
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\t", 4)
+		if len(fields) != 4 {
+			return nil, NewSshConnectionError("Unexpected listing: " + record)
+		}
+		if !filter.allowsFile(fields[3]) {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		modTime, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		files[fields[3]] = syncFile{
+			size:    size,
+			modTime: int64(modTime),
+			mode:    os.FileMode(mode),
+		}
+	}
+	return files, nil
+}
+
+// Joins the relative paths to dir and quotes them as arguments of a remote command.
+func quoteRelativePaths(dir string, relativePaths []string) string {
+	quotedPaths := make([]string, len(relativePaths))
+	for i, relativePath := range relativePaths {
+		quotedPaths[i] = shellQuote(path.Join(dir, relativePath))
+	}
+	return strings.Join(quotedPaths, " ")
+}
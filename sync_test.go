@@ -0,0 +1,77 @@
+package gosher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncPlan(t *testing.T) {
+	source := map[string]syncFile{
+		"same.txt":      {size: 3, modTime: 100},
+		"touched.txt":   {size: 3, modTime: 200},
+		"resized.txt":   {size: 4, modTime: 100},
+		"lib/new/a.go":  {size: 1, modTime: 100},
+		"lib/new/b.go":  {size: 1, modTime: 100},
+		"root-file.txt": {size: 1, modTime: 100},
+	}
+	destination := map[string]syncFile{
+		"same.txt":          {size: 3, modTime: 100},
+		"touched.txt":       {size: 3, modTime: 100},
+		"resized.txt":       {size: 3, modTime: 100},
+		"old/deep/gone.txt": {size: 1, modTime: 100},
+		"old/gone.txt":      {size: 1, modTime: 100},
+	}
+	changed, err := new(SshClient).changedFiles("", "", source, destination, SyncOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lib/new/a.go", "lib/new/b.go", "resized.txt", "root-file.txt", "touched.txt"}, changed,
+		"unchanged files should be skipped")
+
+	deleted := missingFiles(destination, source)
+	assert.Equal(t, []string{"old/deep/gone.txt", "old/gone.txt"}, deleted)
+	assert.Equal(t, []string{".", "lib/new"}, fileDirectories(changed))
+	assert.Equal(t, []string{"old/deep", "old"}, emptiedDirectories(deleted),
+		"the directories should be deleted before their parents")
+	assert.Empty(t, emptiedDirectories([]string{"top.txt"}))
+}
+
+func TestParseRemoteFiles(t *testing.T) {
+	files, err := parseRemoteFiles("3\t100.5\t644\t a\tb\nc \x0010\t200\t755\tbin/run\x00", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]syncFile{
+		" a\tb\nc ": {size: 3, modTime: 100, mode: 0644},
+		"bin/run":   {size: 10, modTime: 200, mode: 0755},
+	}, files)
+
+	filter, _ := newPathFilter(nil, []string{"bin/"})
+	files, _ = parseRemoteFiles("10\t200\t755\tbin/run\x00", filter)
+	assert.Empty(t, files)
+
+	_, err = parseRemoteFiles("garbage\x00", nil)
+	assert.NotNil(t, err)
+}
+
+func TestSyncToHome(t *testing.T) {
+	s, clientError := NewSshClient(serverAddress, username, PasswordAuthentication, password)
+	assert.Nil(t, clientError, "NewSshClient returned an error")
+	_, runError := s.Run("rm -rf ~/syncdir './~'")
+	assert.Nil(t, runError, "removing the previous test folders returned an error")
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "sub", "a"), []byte("synced"), 0644))
+
+	result, syncError := s.Sync(dir, "~/syncdir", SyncOptions{})
+	assert.Nil(t, syncError, "Sync returned an error")
+	assert.Equal(t, []string{"sub/a"}, result.Transferred)
+	result, syncError = s.Sync(dir, "~/syncdir", SyncOptions{})
+	assert.Nil(t, syncError, "Sync returned an error")
+	assert.Empty(t, result.Transferred, "the synced file should be found in the home directory")
+	catResponse, catError := s.Run("cat ~/syncdir/sub/a && ls -d './~' 2>/dev/null | wc -l")
+	assert.Nil(t, catError, "reading the test file returned an error")
+	assert.Equal(t, "synced0\n", catResponse.StdOut.String(),
+		"the folder should be synced in the home directory instead of a folder named ~")
+}
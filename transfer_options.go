@@ -0,0 +1,36 @@
+package gosher
+
+const (
+	SymlinkFollow = iota
+	SymlinkSkip
+	SymlinkCopyLink
+)
+
+// TransferOptions - options for a single upload or download.
+// Resume - false by default, if true and the destination file already exists
+// its contents are verified against the beginning of the source by hash and
+// only the rest of the file is transferred. Applies to single files, folders
+// are always transferred in full.
+// Verify - false by default, if true the SHA-256 of every transferred file is
+// computed locally and remotely (with sha256sum, shasum or openssl) and
+// a ChecksumMismatchError is returned if any of them differ.
+// Include/Exclude - gitignore-style patterns selecting the contents of a folder
+// which are transferred, relative to the folder. Everything which is included
+// (all if there are no Include patterns) and not excluded is transferred.
+// SymlinkPolicy - what happens with the symlinks in a folder: SymlinkFollow (default)
+// transfers what they point to, skipping links back to a parent folder,
+// SymlinkSkip ignores them and SymlinkCopyLink recreates the links themselves.
+// Archive - false by default, if true folders are transferred as a single tar stream
+// instead of file by file, which is much faster for many small files. Falls back to
+// the regular transfer if tar or the compression tool isn't available on the remote machine.
+// ArchiveCompression - the compression of the tar stream: CompressionNone (default),
+// CompressionGzip or CompressionZstd.
+type TransferOptions struct {
+	Resume             bool
+	Verify             bool
+	Include            []string
+	Exclude            []string
+	SymlinkPolicy      int
+	Archive            bool
+	ArchiveCompression int
+}
@@ -1,69 +1,302 @@
 package gosher
 
 import (
-	"fmt"
+	"errors"
+	"github.com/lyuboraykov/gosher/scp"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
-const (
-	scpPushBeginFile   = "C0644"
-	scpPushBeginFolder = "D0755 0"
-	scpPushEndFolder   = "E"
-	scpPushEnd         = "\x00"
-)
-
-func (s *SshClient) uploadFile(localPath string, remotePath string) (*SshResponse, error) {
-	response := NewSshResponse(s.Address, &s.session)
-	go func() {
-		inPipe, _ := s.session.StdinPipe()
-		defer inPipe.Close()
-		writeFileInPipe(inPipe, localPath, filepath.Base(remotePath))
-	}()
+func (s *SshClient) uploadFile(localPath string, remotePath string, meter *transferMeter) (*SshResponse, error) {
+	response := new(SshResponse)
+	response.Address = s.Address
+	s.session.Stderr = &response.StdErr
+	entry := uploadEntry{record: 'C', localPath: localPath, relativePath: filepath.Base(remotePath)}
+	if localPathInfo, err := os.Stat(localPath); err == nil {
+		entry.mode, entry.size = localPathInfo.Mode(), localPathInfo.Size()
+		meter.addTotal(entry.size)
+	}
+	entries := []uploadEntry{entry}
+	return response, s.uploadEntries(entries, filepath.Dir(remotePath), meter)
+}
 
-	if err := s.session.Run("/usr/bin/scp -qvrt " + filepath.Dir(remotePath)); err != nil {
-		return response, NewSshConnectionError("There was an error while uploading: " + err.Error())
+func (s *SshClient) uploadFolder(localPath string, remotePath string, meter *transferMeter,
+	options TransferOptions) (*SshResponse, error) {
+	response := new(SshResponse)
+	response.Address = s.Address
+	s.session.Stderr = &response.StdErr
+	plan, err := planFolderUpload(localPath, options)
+	if err != nil {
+		return response, err
+	}
+	meter.addTotal(plan.size)
+	if err = s.uploadEntries(plan.rootEntries(filepath.Base(remotePath)), filepath.Dir(remotePath), meter); err != nil {
+		return response, err
+	}
+	if err := s.createRemoteLinks(remotePath, plan.links); err != nil {
+		return response, err
 	}
 	return response, nil
 }
 
-func (s *SshClient) uploadFolder(localPath string, remotePath string) (*SshResponse, error) {
-	response := NewSshResponse(s.Address, &s.session)
-	go func() {
-		inPipe, _ := s.session.StdinPipe()
-		defer inPipe.Close()
-		fmt.Fprintln(inPipe, scpPushBeginFolder, filepath.Base(remotePath))
-		writeDirectoryContents(inPipe, localPath)
-		fmt.Fprintln(inPipe, scpPushEndFolder)
-	}()
+// Runs scp in sink mode in targetDirectory and sends the entries to it.
+// Files rejected by the remote machine or which can't be read are skipped and reported together.
+func (s *SshClient) uploadEntries(entries []uploadEntry, targetDirectory string, meter *transferMeter) error {
+	inPipe, err := s.session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	outPipe, err := s.session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = s.session.Start(uploadSinkCommand(targetDirectory)); err != nil {
+		return NewSshConnectionError("There was an error while uploading: " + err.Error())
+	}
+	fileErrors, err := sendUploadEntries(scp.NewSource(inPipe, outPipe), entries, func(entry uploadEntry) (io.ReadCloser, error) {
+		file, err := os.Open(entry.localPath)
+		if err != nil {
+			return nil, err
+		}
+		meter.startFile(entry.localPath)
+		return meteredFile{Reader: meter.reader(file), file: file, meter: meter}, nil
+	})
+	inPipe.Close()
+	// scp exits with 1 if some of the files failed, these are reported instead
+	waitErr := s.session.Wait()
+	if err == nil && len(fileErrors) > 0 {
+		err = joinErrors(fileErrors)
+	}
+	if err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		return NewSshConnectionError("There was an error while uploading: " + err.Error())
+	}
+	return nil
+}
+
+// Returns the command which runs scp in sink mode receiving into targetDirectory.
+func uploadSinkCommand(targetDirectory string) string {
+	return "/usr/bin/scp -qvrt " + shellQuote(targetDirectory)
+}
+
+// Sends the entries through source opening the content of every file with open.
+// Returns the errors of the skipped files and an error if the upload was aborted.
+// The contents of a directory which the remote machine rejects are skipped.
+func sendUploadEntries(source *scp.Source, entries []uploadEntry,
+	open func(entry uploadEntry) (io.ReadCloser, error)) ([]error, error) {
+	var fileErrors []error
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		name := path.Base(entry.relativePath)
+		var err error
+		switch entry.record {
+		case 'D':
+			if err = source.Directory(entry.mode, name); scp.IsWarning(err) {
+				i = endOfDirectory(entries, i)
+			}
+		case 'E':
+			err = source.EndDirectory()
+		default:
+			content, openErr := open(entry)
+			if openErr != nil {
+				if err = source.Warning(name + ": " + openErr.Error()); err == nil {
+					err = &scp.ContentError{Name: name, Err: openErr}
+				}
+				break
+			}
+			err = source.File(entry.mode, entry.size, name, content)
+			content.Close()
+		}
+		if scp.IsFileError(err) {
+			fileErrors = append(fileErrors, err)
+			continue
+		}
+		if err != nil {
+			return fileErrors, err
+		}
+	}
+	return fileErrors, nil
+}
 
-	if err := s.session.Run("/usr/bin/scp -qvrt " + filepath.Dir(remotePath)); err != nil {
-		return response, NewSshConnectionError("Error while uploading: " + err.Error())
+// Returns the index of the entry which ends the directory begun at index begin.
+func endOfDirectory(entries []uploadEntry, begin int) int {
+	depth := 0
+	for i := begin; i < len(entries); i++ {
+		switch entries[i].record {
+		case 'D':
+			depth++
+		case 'E':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
 	}
-	return response, nil
+	return len(entries)
 }
 
-func writeDirectoryContents(inPipe io.WriteCloser, dir string) {
-	fi, _ := ioutil.ReadDir(dir)
+// A single scp record of a folder upload: 'D' begins a folder, 'E' ends it and 'C' is a file.
+// The mode of the local file or folder is sent with it, including the setuid, setgid and sticky bits.
+type uploadEntry struct {
+	record       byte
+	localPath    string
+	relativePath string
+	mode         os.FileMode
+	size         int64
+}
+
+// The scp records of a folder upload in order and the symlinks which
+// have to be created on the remote machine with SymlinkCopyLink.
+type folderUploadPlan struct {
+	entries []uploadEntry
+	// link targets by slash separated path relative to the uploaded folder
+	links map[string]string
+	size  int64
+	// the mode of the uploaded folder
+	mode os.FileMode
+}
+
+// Walks localPath applying the filters and the symlink policy of the options.
+func planFolderUpload(localPath string, options TransferOptions) (*folderUploadPlan, error) {
+	filter, err := newPathFilter(options.Include, options.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	realPath, err := filepath.EvalSymlinks(localPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(realPath)
+	if err != nil {
+		return nil, err
+	}
+	plan := &folderUploadPlan{
+		links: make(map[string]string),
+		mode:  info.Mode(),
+	}
+	ancestors := map[string]bool{realPath: true}
+	err = plan.addDirectory(localPath, "", filter, options.SymlinkPolicy, ancestors)
+	return plan, err
+}
+
+// Returns the entries of the plan inside a directory with the given name.
+func (plan *folderUploadPlan) rootEntries(name string) []uploadEntry {
+	entries := make([]uploadEntry, 0, len(plan.entries)+2)
+	entries = append(entries, uploadEntry{record: 'D', relativePath: name, mode: plan.mode})
+	entries = append(entries, plan.entries...)
+	return append(entries, uploadEntry{record: 'E'})
+}
+
+// ancestors are the real paths of the directories being walked, following
+// a symlink to any of them would never end.
+func (plan *folderUploadPlan) addDirectory(dir string, relativeDir string, filter *pathFilter,
+	symlinkPolicy int, ancestors map[string]bool) error {
+	fi, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
 	for _, f := range fi {
+		localPath := filepath.Join(dir, f.Name())
+		relativePath := path.Join(relativeDir, f.Name())
+		if f.Mode()&os.ModeSymlink != 0 {
+			if symlinkPolicy == SymlinkSkip {
+				continue
+			}
+			if symlinkPolicy == SymlinkCopyLink {
+				if filter.allowsFile(relativePath) {
+					target, err := os.Readlink(localPath)
+					if err != nil {
+						return err
+					}
+					plan.links[relativePath] = target
+				}
+				continue
+			}
+			if f, err = os.Stat(localPath); err != nil {
+				// dangling symlink, nothing to follow
+				continue
+			}
+		}
 		if f.IsDir() {
-			fmt.Fprintln(inPipe, scpPushBeginFolder, f.Name())
-			writeDirectoryContents(inPipe, dir+"/"+f.Name())
-			fmt.Fprintln(inPipe, scpPushEndFolder)
-		} else {
-			writeFileInPipe(inPipe, dir+"/"+f.Name(), f.Name())
+			if !filter.allowsDirectory(relativePath) {
+				continue
+			}
+			realPath, err := filepath.EvalSymlinks(localPath)
+			if err != nil {
+				return err
+			}
+			if ancestors[realPath] {
+				continue
+			}
+			ancestors[realPath] = true
+			beginIndex, linkCount := len(plan.entries), len(plan.links)
+			plan.entries = append(plan.entries, uploadEntry{record: 'D', localPath: localPath, relativePath: relativePath,
+				mode: f.Mode()})
+			err = plan.addDirectory(localPath, relativePath, filter, symlinkPolicy, ancestors)
+			plan.entries = append(plan.entries, uploadEntry{record: 'E'})
+			delete(ancestors, realPath)
+			if err != nil {
+				return err
+			}
+			if filter.hasIncludes() && len(plan.entries) == beginIndex+2 && len(plan.links) == linkCount {
+				// nothing was included from this directory, the links in it need it to exist
+				plan.entries = plan.entries[:beginIndex]
+			}
+		} else if f.Mode().IsRegular() && filter.allowsFile(relativePath) {
+			plan.entries = append(plan.entries, uploadEntry{
+				record:       'C',
+				localPath:    localPath,
+				relativePath: relativePath,
+				mode:         f.Mode(),
+				size:         f.Size(),
+			})
+			plan.size += f.Size()
+		}
+	}
+	return nil
+}
+
+// Creates the symlinks (by path relative to remotePath) on the remote machine.
+func (s *SshClient) createRemoteLinks(remotePath string, links map[string]string) error {
+	var commands []string
+	for relativePath, target := range links {
+		commands = append(commands, "ln -sfn "+shellQuote(target)+" "+shellQuote(path.Join(remotePath, relativePath)))
+	}
+	for start := 0; start < len(commands); start += remoteBatchSize {
+		end := start + remoteBatchSize
+		if end > len(commands) {
+			end = len(commands)
+		}
+		if err := s.runInNewSession(strings.Join(commands[start:end], " && "), nil, nil); err != nil {
+			return NewSshConnectionError("There was an error while creating symlinks: " + err.Error())
 		}
 	}
+	return nil
+}
+
+// meteredFile measures the content of a file as it is read.
+type meteredFile struct {
+	io.Reader
+	file  *os.File
+	meter *transferMeter
 }
 
-func writeFileInPipe(inPipe io.WriteCloser, src string, remoteName string) {
-	fileSrc, _ := os.Open(src)
-	//Get file size
-	srcStat, _ := fileSrc.Stat()
-	// Print the file content
-	fmt.Fprintln(inPipe, scpPushBeginFile, srcStat.Size(), remoteName)
-	io.Copy(inPipe, fileSrc)
-	fmt.Fprint(inPipe, scpPushEnd)
+func (mf meteredFile) Close() error {
+	mf.meter.finishFile()
+	return mf.file.Close()
+}
+
+// Combines the errors of several files into one.
+func joinErrors(errs []error) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(strings.Join(messages, "; "))
 }
@@ -0,0 +1,74 @@
+package gosher
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// TemplateData - the data templates of UploadTemplate and the commands of RunTemplate are rendered with.
+// Address is the address of the host, Alias its alias or address and Vars its variables.
+// Index is the index of the host in the Hosts of a MultipleHostsSshClient, 0 on a single host.
+// Facts are gathered from the remote machine the first time a template uses them,
+// e.g. {{.Facts.hostname}}, see SshClient.Facts.
+type TemplateData struct {
+	Address string
+	Alias   string
+	Index   int
+	Vars    map[string]interface{}
+	client  *SshClient
+}
+
+// Returns the facts of the remote machine.
+func (td *TemplateData) Facts() (map[string]string, error) {
+	return td.client.gatherFacts()
+}
+
+// Renders the text/template at templatePath with the address, vars and facts of the remote machine
+// and uploads the result to remotePath with the given mode, 0 keeps the mode of an existing file.
+// The remote file is replaced atomically and only if its content or mode differ.
+// Using a variable which isn't in vars is an error.
+// Returns an SshResponse with Changed set if the remote file was changed and an error if any has occured.
+func (s *SshClient) UploadTemplate(templatePath string, remotePath string, vars map[string]interface{},
+	mode os.FileMode) (*SshResponse, error) {
+	return s.uploadTemplate(templatePath, remotePath, &TemplateData{
+		Address: s.Address,
+		Alias:   s.Address,
+		Vars:    vars,
+	}, mode)
+}
+
+func (s *SshClient) uploadTemplate(templatePath string, remotePath string, data *TemplateData,
+	mode os.FileMode) (*SshResponse, error) {
+	templateContent, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	parsedTemplate, err := template.New(filepath.Base(templatePath)).Option("missingkey=error").
+		Parse(string(templateContent))
+	if err != nil {
+		return nil, err
+	}
+	sessionErr := s.newSession()
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if !s.StickySession {
+		defer s.CloseSession()
+	}
+	data.client = s
+	var rendered bytes.Buffer
+	if err = parsedTemplate.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+	response := new(SshResponse)
+	response.Address = s.Address
+	previous, changed, err := s.updateRemoteFile(remotePath, rendered.Bytes(), mode)
+	response.Changed = changed
+	if changed && s.CheckMode {
+		response.Diff = contentDiff(remotePath, previous, rendered.Bytes())
+	}
+	return response, err
+}
@@ -0,0 +1,95 @@
+package gosher
+
+import (
+	"github.com/lyuboraykov/gosher/scp"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanFolderUploadKeepsDirectoriesOfLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, directory := range []string{"bin", "lib", "docs"} {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, directory), 0755))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "lib", "tool.sh"), []byte("echo tool"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "docs", "readme.md"), []byte("tool"), 0644))
+	assert.Nil(t, os.Symlink("../lib/tool.sh", filepath.Join(dir, "bin", "tool")))
+
+	plan, err := planFolderUpload(dir, TransferOptions{Include: []string{"tool*"}, SymlinkPolicy: SymlinkCopyLink})
+	assert.Nil(t, err)
+	var records []string
+	for _, entry := range plan.entries {
+		records = append(records, string(entry.record)+entry.relativePath)
+	}
+	assert.Equal(t, []string{"Dbin", "E", "Dlib", "Clib/tool.sh", "E"}, records,
+		"a directory with only links should be kept and one without anything included pruned")
+	assert.Equal(t, map[string]string{"bin/tool": "../lib/tool.sh"}, plan.links)
+}
+
+func TestUploadSinkCommand(t *testing.T) {
+	assert.Equal(t, `/usr/bin/scp -qvrt '/srv/my $app/it'\''s'`, uploadSinkCommand("/srv/my $app/it's"),
+		"the target directory should be quoted")
+}
+
+func TestSendUploadEntriesKeepsModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "bin"), 0755))
+	modes := map[string]os.FileMode{
+		"release": 0700,
+		"bin":     0750,
+		"tool":    0755,
+		"secret":  0600,
+		"suid":    os.ModeSetuid | 0755,
+	}
+	for _, name := range []string{"tool", "secret", "suid"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "bin", name), []byte(name), 0644))
+		assert.Nil(t, os.Chmod(filepath.Join(dir, "bin", name), modes[name]))
+	}
+	assert.Nil(t, os.Chmod(filepath.Join(dir, "bin"), modes["bin"]))
+	assert.Nil(t, os.Chmod(dir, modes["release"]))
+	plan, err := planFolderUpload(dir, TransferOptions{})
+	assert.Nil(t, err)
+
+	toSink, fromSource := io.Pipe()
+	toSource, fromSink := io.Pipe()
+	received := make(chan map[string]os.FileMode, 1)
+	go func() {
+		receivedModes := map[string]os.FileMode{}
+		sink := scp.NewSink(toSink, fromSink)
+		for {
+			record, err := sink.Next()
+			if err != nil {
+				break
+			}
+			if record.Type != scp.EndDirectory {
+				receivedModes[record.Name] = record.Mode
+			}
+			if record.Type == scp.File {
+				err = sink.ReceiveFile(record, ioutil.Discard)
+			} else {
+				err = sink.Ack()
+			}
+			if err != nil {
+				break
+			}
+		}
+		fromSink.Close()
+		received <- receivedModes
+	}()
+	fileErrors, err := sendUploadEntries(scp.NewSource(fromSource, toSource), plan.rootEntries("release"),
+		func(entry uploadEntry) (io.ReadCloser, error) {
+			return os.Open(entry.localPath)
+		})
+	fromSource.Close()
+	assert.Nil(t, err)
+	assert.Empty(t, fileErrors)
+	assert.Equal(t, modes, <-received, "the modes of the local files and folders should be sent")
+}